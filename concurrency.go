@@ -0,0 +1,45 @@
+package oktadance
+
+import (
+	"net/http"
+)
+
+// DefaultMaxConcurrentRequests is how many requests a dance sends to
+// Okta at once, unless overridden via `WithMaxConcurrentRequests`
+const DefaultMaxConcurrentRequests = 8
+
+// WithMaxConcurrentRequests bounds how many requests are in flight to Okta
+// at once, across every call on the dance and the copies made from it by
+// `With`, so fan outs such as `SessionsValid` or `WithRaceFactors` do not
+// trip the org's rate limits. Requests beyond the limit wait for one to
+// finish, or for their context to be done. The default is
+// `DefaultMaxConcurrentRequests`; 0 removes the limit. A copy made with
+// this option has a limit of its own, not counted against the original's.
+func WithMaxConcurrentRequests(n int) Option {
+	return clientOption(func(d *Dance) {
+		d.requestSlots = newSemaphore(n)
+	})
+}
+
+// semaphore limits concurrency, a nil semaphore does not
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// roundTrip sends the request once a slot is free
+func (d *Dance) roundTrip(req *http.Request) (*http.Response, error) {
+	if d.requestSlots != nil {
+		select {
+		case d.requestSlots <- struct{}{}:
+			defer func() { <-d.requestSlots }()
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return d.httpClient.Do(req)
+}
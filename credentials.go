@@ -0,0 +1,40 @@
+package oktadance
+
+import "context"
+
+// CredentialProvider supplies the username and password to authenticate
+// with, ie from a vault or the OS keychain, when they are needed rather
+// than ahead of time
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (username, password string, err error)
+}
+
+// CredentialsFunc adapts a function to a `CredentialProvider`
+type CredentialsFunc func(ctx context.Context) (username, password string, err error)
+
+// Credentials calls the function
+func (f CredentialsFunc) Credentials(ctx context.Context) (username, password string, err error) {
+	return f(ctx)
+}
+
+// AuthenticateWithProvider authenticates as `Authenticate` does, obtaining
+// the username and password from the provider
+func (d *Dance) AuthenticateWithProvider(ctx context.Context, provider CredentialProvider, mfa Multifactor) (SessionToken, error) {
+	username, password, err := provider.Credentials(ctx)
+	if err != nil {
+		return "", err
+	}
+	return d.Authenticate(ctx, username, password, mfa)
+}
+
+// Credentials asks the user for their username and password, making the
+// console a `CredentialProvider`
+func (c *ConsoleMultifactor) Credentials(ctx context.Context) (username, password string, err error) {
+	return c.RequestUsernamePasswordContext(ctx)
+}
+
+// Credentials returns the preset username and password, reading any which
+// were not set, making the reader a `CredentialProvider`
+func (r *ReaderMultifactor) Credentials(ctx context.Context) (username, password string, err error) {
+	return r.RequestUsernamePassword()
+}
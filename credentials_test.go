@@ -0,0 +1,87 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/chzyer/readline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_AuthenticateWithProvider(t *testing.T) {
+	var got map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	provider := oktadance.NewReaderMultifactor(strings.NewReader("user\npass\n"), nil)
+	token, err := d.AuthenticateWithProvider(context.Background(), provider, nil)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Equal(t, "user", got["username"])
+	assert.Equal(t, "pass", got["password"])
+
+	vault := oktadance.CredentialsFunc(func(context.Context) (string, string, error) {
+		return "", "", errors.New("vault sealed")
+	})
+	_, err = d.AuthenticateWithProvider(context.Background(), vault, nil)
+	assert.EqualError(t, err, "vault sealed")
+}
+
+func newPipeConsole(t *testing.T) (*oktadance.ConsoleMultifactor, *io.PipeWriter) {
+	r, w := io.Pipe()
+	l, err := readline.NewEx(&readline.Config{
+		Stdin:          r,
+		Stdout:         ioutil.Discard,
+		Stderr:         ioutil.Discard,
+		FuncIsTerminal: func() bool { return false },
+	})
+	require.NoError(t, err)
+	return &oktadance.ConsoleMultifactor{Instance: l}, w
+}
+
+func TestConsoleMultifactor_RequestUsernamePasswordContext(t *testing.T) {
+	c, w := newPipeConsole(t)
+	defer w.Close() // lets the abandoned read, and so the close, finish
+	go io.WriteString(w, "user \npass\n")
+
+	username, password, err := c.RequestUsernamePasswordContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.RequestUsernamePasswordContext(ctx)
+		done <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("blocked read was not interrupted by cancellation")
+	}
+
+	_, _, err = c.RequestUsernamePasswordContext(ctx)
+	assert.Equal(t, context.Canceled, err, "an already cancelled context should not read")
+}
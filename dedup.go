@@ -0,0 +1,101 @@
+package oktadance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+)
+
+// WithAuthnDedup makes concurrent `Authenticate` calls for the same
+// credentials share a single authn transaction, so that a client retrying
+// on a flaky connection does not consume rate limits or count towards
+// lockout. The calls all receive the result of the first; if MFA is
+// required it is completed by the first call's `Multifactor`, and the
+// first call's context governs the shared transaction. Calls given
+// options, via `AuthnRequest.Options` or `ContextWithOptions`, are never
+// shared, as their options cannot be compared. Copies made by `Dance.With`
+// only share transactions which are sent, in the same way, to the same org.
+func WithAuthnDedup() Option {
+	return setupOption(func(d *Dance) {
+		d.authnFlights = &authnFlights{calls: map[string]*authnFlight{}}
+	})
+}
+
+// authnFlights tracks the in flight authn transactions
+type authnFlights struct {
+	mu    sync.Mutex
+	calls map[string]*authnFlight
+}
+
+type authnFlight struct {
+	wg     sync.WaitGroup
+	result *AuthnResult
+	err    error
+}
+
+// do runs fn unless a call with the same key is already in flight, in
+// which case it waits for, and returns, that call's result
+func (f *authnFlights) do(key string, fn func() (*AuthnResult, error)) (*AuthnResult, error) {
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		c.wg.Wait()
+		return c.copyResult(), c.err
+	}
+	c := &authnFlight{}
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	c.result, c.err = fn()
+	c.wg.Done()
+
+	f.mu.Lock()
+	delete(f.calls, key)
+	f.mu.Unlock()
+
+	return c.copyResult(), c.err
+}
+
+// copyResult gives each caller its own result to modify
+func (c *authnFlight) copyResult() *AuthnResult {
+	if c.result == nil {
+		return nil
+	}
+	r := *c.result
+	return &r
+}
+
+// authnFlightKey identifies identical authn requests, including the
+// settings of the dance which shape them, as the flights are shared by
+// copies made via `With`. The password is hashed so it is not held in
+// the map.
+func (d *Dance) authnFlightKey(request AuthnRequest) string {
+	h := sha256.New()
+	for _, s := range []string{
+		d.oktaDomain,
+		d.clientID,
+		d.forwardedFor,
+		d.deviceFingerprint,
+		d.loginHint,
+		request.Username,
+		request.Password,
+		request.Audience,
+		request.DeviceToken,
+		strconv.FormatBool(request.MultiOptionalFactorEnroll),
+		strconv.FormatBool(request.WarnBeforePasswordExpired),
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// dedupable reports whether the request may share a transaction with
+// others, see `WithAuthnDedup`
+func dedupable(ctx context.Context, request AuthnRequest) bool {
+	opts, _ := ctx.Value(callOptionsKey{}).([]Option)
+	return len(request.Options) == 0 && len(opts) == 0
+}
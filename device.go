@@ -0,0 +1,191 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDeviceAccessDenied is returned when the user declines the
+	// device authorization request
+	ErrDeviceAccessDenied = errors.New("device authorization was denied")
+
+	// ErrDeviceCodeExpired is returned when the device code expires
+	// before the user approves the request
+	ErrDeviceCodeExpired = errors.New("device code expired")
+)
+
+// defaultDeviceInterval is the polling interval used when Okta
+// does not specify one, as per RFC 8628
+const defaultDeviceInterval = 5 * time.Second
+
+// DeviceAuthorization is the response from starting a device
+// authorization grant. The user must visit VerificationURI and
+// enter UserCode to approve the device.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// OAuthToken holds the tokens issued by the Okta token endpoint
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// StartDeviceFlow begins an OAuth 2.0 device authorization grant. The
+// returned `DeviceAuthorization` carries the code the user must enter
+// at the verification URI, after which `PollDeviceToken` will obtain
+// the tokens.
+//
+// This method requires a configured clientID for an App with the
+// device authorization grant enabled.
+func (d *Dance) StartDeviceFlow(ctx context.Context) (*DeviceAuthorization, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	form := url.Values{}
+	form.Set("client_id", d.clientID)
+	form.Set("scope", "openid profile offline_access")
+
+	u, err := d.endpoint(ctx, "device/authorize")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := d.postForm(ctx, "StartDeviceFlow", u, form)
+	if err != nil {
+		return nil, err
+	}
+
+	da := &DeviceAuthorization{}
+	err = json.Unmarshal(body, da)
+	if err != nil {
+		return nil, err
+	}
+
+	return da, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user approves or
+// denies the device authorization, or it expires. It honors the polling
+// interval given by Okta, backing off when asked to `slow_down`.
+func (d *Dance) PollDeviceToken(ctx context.Context, da *DeviceAuthorization) (*OAuthToken, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	interval := time.Duration(da.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDeviceInterval
+	}
+
+	form := url.Values{}
+	form.Set("client_id", d.clientID)
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	form.Set("device_code", da.DeviceCode)
+
+	u, err := d.endpoint(ctx, "token")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		body, err := d.postForm(ctx, "PollDeviceToken", u, form)
+		if err == nil {
+			token := &OAuthToken{}
+			err = json.Unmarshal(body, token)
+			if err != nil {
+				return nil, err
+			}
+			return token, nil
+		}
+
+		var oe *OAuthError
+		if !errors.As(err, &oe) {
+			return nil, err
+		}
+		switch oe.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, ErrDeviceAccessDenied
+		case "expired_token":
+			return nil, ErrDeviceCodeExpired
+		default:
+			return nil, err
+		}
+
+		select {
+		case <-d.after(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// postForm posts a form to an OAuth endpoint, returning the body of
+// a successful response or the `*OAuthError` from a failed one
+func (d *Dance) postForm(ctx context.Context, name, u string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	form = copyValues(form)
+	d.authenticateClient(req, form)
+	enc := form.Encode()
+	req.Body = ioutil.NopCloser(strings.NewReader(enc))
+	req.ContentLength = int64(len(enc))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.do(name, req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 400 {
+		oe := &OAuthError{}
+		if json.Unmarshal(body, oe) == nil && oe.Code != "" {
+			return nil, oe
+		}
+		return nil, fmt.Errorf("%s failed, status %d: %s", name, res.StatusCode, sanitizeBody(body))
+	}
+
+	return body, nil
+}
+
+// copyValues copies the form, so adding credentials to it
+// does not modify the caller's
+func copyValues(v url.Values) url.Values {
+	cp := url.Values{}
+	for k, vs := range v {
+		cp[k] = append([]string(nil), vs...)
+	}
+	return cp
+}
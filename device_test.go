@@ -0,0 +1,68 @@
+package oktadance_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_DeviceFlow(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/device/authorize", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(r.ParseForm())
+		assert.Equal("client", r.PostForm.Get("client_id"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"device_code":      "device",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": "https://example.okta.com/activate",
+			"expires_in":       600,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/oauth2/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(r.ParseForm())
+		assert.Equal("device", r.PostForm.Get("device_code"))
+		polls++
+		if polls == 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     "id",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithPrettyJSON())
+	defer srv.Close()
+
+	ctx := context.Background()
+	da, err := d.StartDeviceFlow(ctx)
+	require.NoError(err)
+	assert.Equal("ABCD-EFGH", da.UserCode)
+
+	token, err := d.PollDeviceToken(ctx, da)
+	require.NoError(err)
+	assert.Equal("access", token.AccessToken)
+	assert.Equal(2, polls)
+}
+
+func TestDance_DeviceFlow_Denied(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "access_denied"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	_, err := d.PollDeviceToken(context.Background(), &oktadance.DeviceAuthorization{DeviceCode: "device"})
+	assert.Equal(t, oktadance.ErrDeviceAccessDenied, err)
+}
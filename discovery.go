@@ -0,0 +1,181 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// ProviderMetadata is the OIDC discovery document of an authorization
+// server, as published at `/.well-known/openid-configuration`
+type ProviderMetadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	UserinfoEndpoint            string `json:"userinfo_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	EndSessionEndpoint          string `json:"end_session_endpoint"`
+	RevocationEndpoint          string `json:"revocation_endpoint"`
+	IntrospectionEndpoint       string `json:"introspection_endpoint"`
+}
+
+// endpoint gives the advertised url of one of the `oauthURL` endpoints
+func (m *ProviderMetadata) endpoint(endpoint string) string {
+	switch endpoint {
+	case "authorize":
+		return m.AuthorizationEndpoint
+	case "token":
+		return m.TokenEndpoint
+	case "userinfo":
+		return m.UserinfoEndpoint
+	case "keys":
+		return m.JWKSURI
+	case "device/authorize":
+		return m.DeviceAuthorizationEndpoint
+	case "logout":
+		return m.EndSessionEndpoint
+	case "revoke":
+		return m.RevocationEndpoint
+	case "introspect":
+		return m.IntrospectionEndpoint
+	}
+	return ""
+}
+
+// WithDiscovery makes the OAuth operations use the endpoints advertised
+// in the authorization server's OIDC discovery document, rather than
+// assuming Okta's standard layout. The document is fetched once, on
+// first use, and cached.
+func WithDiscovery() Option {
+	return option(func(d *Dance) {
+		d.discovery = true
+	})
+}
+
+// metadataCache holds the discovery document fetched from each
+// discovery url
+type metadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*ProviderMetadata
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: map[string]*ProviderMetadata{}}
+}
+
+// Discover fetches the OIDC discovery document of the configured
+// authorization server, returning the cached copy if it has already
+// been fetched
+func (d *Dance) Discover(ctx context.Context) (*ProviderMetadata, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	c := d.metadata
+	u := d.discoveryURL()
+	c.mu.Lock()
+	m, ok := c.entries[u]
+	c.mu.Unlock()
+	if ok {
+		return m, nil
+	}
+
+	// the lock is not held while fetching, so a slow fetch does not hold
+	// up the calls using documents already cached
+	m, err := d.fetchMetadata(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if cached, ok := c.entries[u]; ok {
+		// a concurrent call fetched it first
+		m = cached
+	} else {
+		c.entries[u] = m
+	}
+	c.mu.Unlock()
+
+	return m, nil
+}
+
+// fetchMetadata retrieves the discovery document at u
+func (d *Dance) fetchMetadata(ctx context.Context, u string) (*ProviderMetadata, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.do("Discover", req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, oktaError(res, body)
+	}
+
+	m := &ProviderMetadata{}
+	err = json.Unmarshal(body, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// endpoint is the url of an OAuth endpoint, as advertised by discovery
+// when enabled, otherwise as given by `oauthURL`
+func (d *Dance) endpoint(ctx context.Context, endpoint string) (string, error) {
+	if !d.discovery {
+		return d.oauthURL(endpoint), nil
+	}
+
+	m, err := d.Discover(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error discovering %s endpoint: %w", endpoint, err)
+	}
+	if u := m.endpoint(endpoint); u != "" {
+		return u, nil
+	}
+	return d.oauthURL(endpoint), nil
+}
+
+// discoveryURL is the url of the OIDC metadata for the
+// configured authorization server
+func (d *Dance) discoveryURL() string {
+	if d.authServer == "" {
+		return fmt.Sprintf("https://%s/.well-known/openid-configuration", d.oktaDomain)
+	}
+	return fmt.Sprintf("https://%s/oauth2/%s/.well-known/openid-configuration", d.oktaDomain, url.PathEscape(d.authServer))
+}
+
+// issuer is the `iss` of tokens from the configured authorization server,
+// as advertised by discovery when enabled
+func (d *Dance) issuer(ctx context.Context) (string, error) {
+	if d.discovery {
+		m, err := d.Discover(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error discovering issuer: %w", err)
+		}
+		if m.Issuer != "" {
+			return m.Issuer, nil
+		}
+	}
+	if d.authServer == "" {
+		return fmt.Sprintf("https://%s", d.oktaDomain), nil
+	}
+	return fmt.Sprintf("https://%s/oauth2/%s", d.oktaDomain, url.PathEscape(d.authServer)), nil
+}
@@ -0,0 +1,91 @@
+package oktadance_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_Discovery(t *testing.T) {
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	discovered := 0
+	mux := http.NewServeMux()
+	var base string
+	mux.HandleFunc("/oauth2/aus123/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		discovered++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                 base + "/oauth2/aus123",
+			"authorization_endpoint": base + "/custom/authorize",
+			"jwks_uri":               base + "/custom/keys",
+		})
+	})
+	mux.HandleFunc("/custom/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/custom/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux,
+		oktadance.WithClientID("client"),
+		oktadance.WithVerifyIDToken(),
+		oktadance.WithAuthorizationServer("aus123"),
+		oktadance.WithDiscovery(),
+	)
+	defer srv.Close()
+	base = srv.URL
+	claims["iss"] = base + "/oauth2/aus123"
+
+	for i := 0; i < 2; i++ {
+		ar, err := d.AuthorizeToken(context.Background(), "token")
+		require.NoError(t, err)
+		assert.Equal(t, "00u123", ar.Claims.Subject)
+	}
+	assert.Equal(t, 1, discovered, "discovery document should be cached")
+
+	m, err := d.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, base+"/oauth2/aus123", m.Issuer)
+}
+
+func TestDance_Discover_NotLockedDuringFetch(t *testing.T) {
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/slow/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(fetching) })
+		<-release
+		writeJSON(w, http.StatusOK, map[string]interface{}{"issuer": "https://example.okta.com/oauth2/slow"})
+	})
+	mux.HandleFunc("/oauth2/default/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"issuer": "https://example.okta.com/oauth2/default"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAuthorizationServer("default"))
+	defer srv.Close()
+
+	ctx := context.Background()
+	cached, err := d.Discover(ctx)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.With(oktadance.WithAuthorizationServer("slow")).Discover(ctx)
+		done <- err
+	}()
+	<-fetching
+
+	m, err := d.Discover(ctx)
+	require.NoError(t, err, "cached documents should be usable while a fetch is in progress")
+	assert.Same(t, cached, m)
+
+	close(release)
+	assert.NoError(t, <-done)
+}
@@ -1,5 +1,7 @@
 package oktadance
 
+import "time"
+
 /*
 From: https://github.com/segmentio/aws-okta/blob/47e49fc370584c1509fe378fdff232a63219ce0e/lib/struct.go
 
@@ -33,6 +35,23 @@ type oktaUser struct {
 	Password string `json:"password"`
 }
 
+type oktaAuthnRequest struct {
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Audience string            `json:"audience,omitempty"`
+	Options  *oktaAuthnOptions `json:"options,omitempty"`
+	Context  *oktaAuthnContext `json:"context,omitempty"`
+}
+
+type oktaAuthnOptions struct {
+	MultiOptionalFactorEnroll bool `json:"multiOptionalFactorEnroll"`
+	WarnBeforePasswordExpired bool `json:"warnBeforePasswordExpired"`
+}
+
+type oktaAuthnContext struct {
+	DeviceToken string `json:"deviceToken,omitempty"`
+}
+
 type oktaStateToken struct {
 	StateToken string `json:"stateToken"`
 	PassCode   string `json:"passCode"`
@@ -42,14 +61,75 @@ type oktaUserAuthn struct {
 	StateToken   string                `json:"stateToken"`
 	SessionToken string                `json:"sessionToken"`
 	ExpiresAt    string                `json:"expiresAt"`
-	Status       string                `json:"status"`
+	Status       AuthnStatus           `json:"status"`
 	Embedded     oktaUserAuthnEmbedded `json:"_embedded"`
-	FactorResult string                `json:"factorResult"`
+	FactorResult FactorResult          `json:"factorResult"`
+	FactorType   string                `json:"factorType"`
+	RecoveryType string                `json:"recoveryType"`
+	Links        oktaUserAuthnLinks    `json:"_links"`
+}
+
+func (o oktaUserAuthn) expiresAt() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, o.ExpiresAt)
+	return t, err == nil
+}
+
+// pollURL is the endpoint to poll a pending factor, if Okta gave one
+func (o oktaUserAuthn) pollURL() string {
+	if o.Links.Next.Name == "poll" {
+		return o.Links.Next.Href
+	}
+	return ""
+}
+
+type oktaUserAuthnLinks struct {
+	Next   oktaLink `json:"next"`
+	Prev   oktaLink `json:"prev"`
+	Skip   oktaLink `json:"skip"`
+	Cancel oktaLink `json:"cancel"`
+}
+
+// links gives the hrefs of the transaction's links by name, the next
+// step under the name Okta gave it, ie `unlock` or `resetPassword`
+func (l oktaUserAuthnLinks) links() map[string]string {
+	links := map[string]string{}
+	if l.Next.Href != "" {
+		links[l.Next.Name] = l.Next.Href
+	}
+	if l.Prev.Href != "" {
+		links["prev"] = l.Prev.Href
+	}
+	if l.Skip.Href != "" {
+		links["skip"] = l.Skip.Href
+	}
+	if l.Cancel.Href != "" {
+		links["cancel"] = l.Cancel.Href
+	}
+	return links
+}
+
+type oktaLink struct {
+	Name string `json:"name"`
+	Href string `json:"href"`
 }
 
 type oktaUserAuthnEmbedded struct {
 	Factors []oktaUserAuthnFactor `json:"factors"`
 	Factor  oktaUserAuthnFactor   `json:"factor"`
+	Policy  oktaUserAuthnPolicy   `json:"policy"`
+	User    oktaUserAuthnUser     `json:"user"`
+}
+
+type oktaUserAuthnUser struct {
+	RecoveryQuestion struct {
+		Question string `json:"question"`
+	} `json:"recovery_question"`
+}
+
+type oktaUserAuthnPolicy struct {
+	Expiration struct {
+		PasswordExpireDays int `json:"passwordExpireDays"`
+	} `json:"expiration"`
 }
 
 func (ouae oktaUserAuthnEmbedded) factors() []Factor {
@@ -64,14 +144,25 @@ type oktaUserAuthnFactor struct {
 	ID         string                      `json:"id"`
 	FactorType string                      `json:"factorType"`
 	Provider   string                      `json:"provider"`
+	Status     string                      `json:"status"`
 	Embedded   oktaUserAuthnFactorEmbedded `json:"_embedded"`
 	Profile    oktaUserAuthnFactorProfile  `json:"profile"`
+	Links      oktaUserAuthnFactorLinks    `json:"_links"`
+}
+
+type oktaUserAuthnFactorLinks struct {
+	Verify oktaLink `json:"verify"`
 }
 
 type oktaUserAuthnFactorProfile struct {
 	CredentialID string `json:"credentialId"`
 	AppID        string `json:"appId"`
 	Version      string `json:"version"`
+	PhoneNumber  string `json:"phoneNumber"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	DeviceType   string `json:"deviceType"`
+	Platform     string `json:"platform"`
 }
 
 type oktaUserAuthnFactorEmbedded struct {
@@ -89,6 +180,7 @@ type oktaUserAuthnFactorEmbeddedVerification struct {
 type oktaUserAuthnFactorEmbeddedChallenge struct {
 	Nonce           string `json:"nonce"`
 	TimeoutSeconnds int    `json:"timeoutSeconds"`
+	CorrectAnswer   int    `json:"correctAnswer"`
 }
 type oktaUserAuthnFactorEmbeddedVerificationLinks struct {
 	Complete oktaUserAuthnFactorEmbeddedVerificationLinksComplete `json:"complete"`
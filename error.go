@@ -0,0 +1,133 @@
+package oktadance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// OktaError is an error returned by the Okta API, see
+// [Error Object](https://developer.okta.com/docs/reference/error-codes/)
+type OktaError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int `json:"-"`
+
+	ErrorCode    string `json:"errorCode"`
+	ErrorSummary string `json:"errorSummary"`
+	ErrorLink    string `json:"errorLink"`
+	ErrorID      string `json:"errorId"`
+	ErrorCauses  []struct {
+		ErrorSummary string `json:"errorSummary"`
+	} `json:"errorCauses"`
+}
+
+func (e *OktaError) Error() string {
+	msg := fmt.Sprintf("okta error %s (status %d): %s", e.ErrorCode, e.StatusCode, e.ErrorSummary)
+	causes := []string{}
+	for _, c := range e.ErrorCauses {
+		causes = append(causes, c.ErrorSummary)
+	}
+	if len(causes) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, strings.Join(causes, "; "))
+	}
+	return msg
+}
+
+// oktaError builds an error from a failed Okta API response body,
+// returning an `*OktaError` if the body is an Okta error object
+func oktaError(res *http.Response, body []byte) error {
+	oe := &OktaError{}
+	if json.Unmarshal(body, oe) == nil && oe.ErrorCode != "" {
+		oe.StatusCode = res.StatusCode
+		return oe
+	}
+	return fmt.Errorf("unexpected status %d: %s", res.StatusCode, sanitizeBody(body))
+}
+
+// ErrSessionNotFound matches (via `errors.Is`) the error from `Session`
+// when Okta does not know the session, ie it never existed or has been
+// closed or expired and since removed. The error is also an `*OktaError`.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionInactive is returned from `Session` when Okta reports the
+// session exists but is no longer active
+var ErrSessionInactive = errors.New("session is inactive")
+
+// sessionNotFound marks an `*OktaError` as `ErrSessionNotFound`
+type sessionNotFound struct {
+	*OktaError
+}
+
+func (e sessionNotFound) Unwrap() error {
+	return e.OktaError
+}
+
+func (e sessionNotFound) Is(target error) bool {
+	return target == ErrSessionNotFound
+}
+
+// ErrInteractionRequired matches an `*OAuthError` (via `errors.Is`) when
+// Okta could not complete a silent (`prompt=none`) authorize, ie
+// `login_required`, `interaction_required`, or `consent_required`.
+// Callers should fall back to an interactive flow rather than retry.
+var ErrInteractionRequired = errors.New("interaction required")
+
+// interactionCodes are the OAuth error codes meaning silent
+// authorization cannot proceed
+var interactionCodes = map[string]bool{
+	"login_required":             true,
+	"interaction_required":       true,
+	"consent_required":           true,
+	"account_selection_required": true,
+}
+
+// OAuthError is an OAuth 2.0 error, returned either in the body of a
+// failed token request or as parameters on an authorize redirect
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// Is reports whether the error is `ErrInteractionRequired`
+func (e *OAuthError) Is(target error) bool {
+	return target == ErrInteractionRequired && interactionCodes[e.Code]
+}
+
+// ErrUnexpectedContentType is returned when Okta, or a proxy in front of
+// it, responds with something other than JSON, ie an HTML maintenance or
+// error page. The error includes the start of the body.
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// snippetLen is how much of an unexpected body is included in errors
+const snippetLen = 200
+
+// checkJSON returns an error wrapping `ErrUnexpectedContentType` unless the
+// response is JSON. Empty bodies and responses without a content type
+// are given the benefit of the doubt.
+func checkJSON(res *http.Response, body []byte) error {
+	ct := res.Header.Get("Content-Type")
+	if ct == "" || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err == nil && (mt == "application/json" || strings.HasSuffix(mt, "+json")) {
+		return nil
+	}
+
+	snippet := sanitizeBody(body)
+	if len(snippet) > snippetLen {
+		snippet = snippet[:snippetLen] + "..."
+	}
+	return fmt.Errorf("%w %q, status %d: %s", ErrUnexpectedContentType, ct, res.StatusCode, snippet)
+}
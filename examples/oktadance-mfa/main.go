@@ -35,6 +35,7 @@ func run(clientID, domain string) error {
 	if err != nil {
 		return err
 	}
+	defer mfa.Close()
 
 	username, password, err := mfa.RequestUsernamePassword()
 	if err != nil {
@@ -42,6 +43,7 @@ func run(clientID, domain string) error {
 	}
 
 	okta := oktadance.New(domain, oktadance.WithClientID(clientID))
+	defer okta.Close()
 
 	sessionToken, err := okta.Authenticate(ctx, username, password, mfa)
 	if err != nil {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brianm/oktadance"
+)
+
+// totpFactors are the TOTP factors to use, in order of preference
+var totpFactors = []oktadance.FactorPreference{
+	{FactorType: "token:software:totp", Provider: "OKTA"},
+	{FactorType: "token:software:totp", Provider: "GOOGLE"},
+	{FactorType: "token:software:totp"},
+}
+
+func main() {
+	clientID, ok := os.LookupEnv("OKTA_CLIENT_ID")
+	if !ok {
+		fmt.Fprintln(os.Stderr, "must set $OKTA_CLIENT_ID to the clientId for the app")
+		os.Exit(1)
+	}
+
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s OKTA_DOMAIN\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	err := run(clientID, os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(clientID, domain string) error {
+	ctx := context.Background()
+
+	console, err := oktadance.NewConsoleMultifactor()
+	if err != nil {
+		return err
+	}
+	defer console.Close()
+	console.Preferences = totpFactors
+
+	username, password, err := console.RequestUsernamePassword()
+	if err != nil {
+		return err
+	}
+
+	okta := oktadance.New(domain,
+		oktadance.WithClientID(clientID),
+		oktadance.WithFactorPreference(totpFactors...),
+	)
+	defer okta.Close()
+
+	sessionToken, err := okta.Authenticate(ctx, username, password, totp{console})
+	if err != nil {
+		return err
+	}
+
+	sid, err := okta.Authorize(ctx, sessionToken)
+	if err != nil {
+		return err
+	}
+
+	sess, err := okta.Session(ctx, sid)
+	if err != nil {
+		return err
+	}
+
+	d := time.Until(sess.ExpiresAt)
+	fmt.Printf("sid\t%s\n", sid)
+	fmt.Printf("expires\t%s\n", d)
+
+	err = okta.CloseSession(ctx, sid)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// totp tells the user where to find their code before reading it
+type totp struct {
+	*oktadance.ConsoleMultifactor
+}
+
+func (t totp) ReadCode(f oktadance.Factor) (string, error) {
+	fmt.Printf("enter the code from your %s authenticator app\n", f.Provider())
+	return t.ConsoleMultifactor.ReadCode(f)
+}
@@ -0,0 +1,129 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// ListFactors lists the factors enrolled for a user, along with their
+// status (ie `ACTIVE` or `PENDING_ACTIVATION`). Unlike the factors
+// offered during `Authenticate`, this does not require a login to be
+// in progress.
+//
+// This method requires an API token, configured via `WithAPIToken`.
+func (d *Dance) ListFactors(ctx context.Context, userID string) ([]Factor, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	body, err := d.api(ctx, "ListFactors", "GET", fmt.Sprintf("/api/v1/users/%s/factors", url.PathEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ofs := []oktaUserAuthnFactor{}
+	err = json.Unmarshal(body, &ofs)
+	if err != nil {
+		return nil, err
+	}
+
+	factors := []Factor{}
+	for _, f := range ofs {
+		factors = append(factors, f.factor())
+	}
+	return factors, nil
+}
+
+// DeleteFactor unenrolls a factor from a user, ie to reset a lost
+// authenticator. Failures, such as attempting to remove a factor
+// required by policy, are reported as an `*OktaError`.
+//
+// This method requires an API token, configured via `WithAPIToken`.
+func (d *Dance) DeleteFactor(ctx context.Context, userID, factorID string) error {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	path := fmt.Sprintf("/api/v1/users/%s/factors/%s", url.PathEscape(userID), url.PathEscape(factorID))
+	_, err := d.api(ctx, "DeleteFactor", "DELETE", path, nil)
+	return err
+}
+
+// SupportedFactor is a factor type which the org allows a user to enroll
+type SupportedFactor struct {
+	FactorType string `json:"factorType"`
+	Provider   string `json:"provider"`
+	VendorName string `json:"vendorName"`
+
+	// Enrollment is whether policy requires the factor, `REQUIRED`,
+	// or leaves it to the user, `OPTIONAL`
+	Enrollment string `json:"enrollment"`
+
+	// Status is whether the user has enrolled the factor, ie `NOT_SETUP`
+	// or `ACTIVE`
+	Status string `json:"status"`
+}
+
+// SupportedFactors lists the factor types the user may enroll, as allowed
+// by the org's policies, ie to show only the permitted options in an
+// enrollment wizard.
+//
+// This method requires an API token, configured via `WithAPIToken`.
+func (d *Dance) SupportedFactors(ctx context.Context, userID string) ([]SupportedFactor, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	body, err := d.api(ctx, "SupportedFactors", "GET", fmt.Sprintf("/api/v1/users/%s/factors/catalog", url.PathEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	factors := []SupportedFactor{}
+	err = json.Unmarshal(body, &factors)
+	if err != nil {
+		return nil, err
+	}
+	return factors, nil
+}
+
+// api makes a request to the Okta management API, authenticated with
+// the configured API token
+func (d *Dance) api(ctx context.Context, name, method, path string, reqBody io.Reader) ([]byte, error) {
+	if d.apiToken == "" {
+		return nil, ErrNoAPIToken
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("https://%s%s", d.oktaDomain, path), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "SSWS "+d.apiToken)
+	req.Header.Set("Accept", "application/json")
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := d.do(name, req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, oktaError(res, body)
+	}
+
+	return body, nil
+}
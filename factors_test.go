@@ -0,0 +1,143 @@
+package oktadance_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_ListFactors(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/00u1/factors", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("SSWS secret", r.Header.Get("Authorization"))
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"id": "push1", "factorType": "push", "provider": "OKTA", "status": "ACTIVE"},
+			{"id": "sms1", "factorType": "sms", "provider": "OKTA", "status": "PENDING_ACTIVATION"},
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	factors, err := d.ListFactors(context.Background(), "00u1")
+	require.NoError(err)
+	require.Len(factors, 2)
+	assert.Equal("push", factors[0].FactorType())
+	assert.Equal("ACTIVE", factors[0].Status())
+	assert.Equal("PENDING_ACTIVATION", factors[1].Status())
+}
+
+func TestFactor_VerificationLinks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/00u1/factors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{
+				"id":         "duo1",
+				"factorType": "web",
+				"provider":   "DUO",
+				"_links": map[string]interface{}{
+					"verify": map[string]interface{}{"href": "https://example.okta.com/api/v1/users/00u1/factors/duo1/verify"},
+				},
+				"_embedded": map[string]interface{}{
+					"verification": map[string]interface{}{
+						"_links": map[string]interface{}{
+							"complete": map[string]interface{}{"href": "https://example.okta.com/api/v1/authn/factors/duo1/lifecycle/duoCallback"},
+						},
+					},
+				},
+			},
+			{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	factors, err := d.ListFactors(context.Background(), "00u1")
+	require.NoError(t, err)
+	require.Len(t, factors, 2)
+	assert.Equal(t, oktadance.FactorLinks{
+		Verify:   "https://example.okta.com/api/v1/users/00u1/factors/duo1/verify",
+		Complete: "https://example.okta.com/api/v1/authn/factors/duo1/lifecycle/duoCallback",
+	}, factors[0].VerificationLinks())
+	assert.Equal(t, oktadance.FactorLinks{}, factors[1].VerificationLinks())
+}
+
+func TestDance_ListFactors_Errors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/nobody/factors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"errorCode":    "E0000007",
+			"errorSummary": "Not found: Resource not found: nobody (User)",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	_, err := d.ListFactors(context.Background(), "nobody")
+	var oe *oktadance.OktaError
+	require.True(t, errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal(t, "E0000007", oe.ErrorCode)
+	assert.Equal(t, http.StatusNotFound, oe.StatusCode)
+
+	d, srv = mockOkta(t, mux)
+	defer srv.Close()
+	_, err = d.ListFactors(context.Background(), "nobody")
+	assert.Equal(t, oktadance.ErrNoAPIToken, err)
+}
+
+func TestDance_DeleteFactor(t *testing.T) {
+	deleted := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/00u1/factors/sms1", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "DELETE", r.Method)
+		deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/api/v1/users/00u1/factors/push1", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"errorCode":    "E0000006",
+			"errorSummary": "You do not have permission to perform the requested action",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	ctx := context.Background()
+	require.NoError(t, d.DeleteFactor(ctx, "00u1", "sms1"))
+	assert.True(t, deleted)
+
+	err := d.DeleteFactor(ctx, "00u1", "push1")
+	var oe *oktadance.OktaError
+	require.True(t, errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal(t, "E0000006", oe.ErrorCode)
+}
+
+func TestDance_SupportedFactors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/00u1/factors/catalog", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "SSWS secret", r.Header.Get("Authorization"))
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"factorType": "token:software:totp", "provider": "GOOGLE", "enrollment": "OPTIONAL", "status": "NOT_SETUP"},
+			{"factorType": "push", "provider": "OKTA", "vendorName": "OKTA", "enrollment": "REQUIRED", "status": "ACTIVE"},
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	factors, err := d.SupportedFactors(context.Background(), "00u1")
+	require.NoError(t, err)
+	assert.Equal(t, []oktadance.SupportedFactor{
+		{FactorType: "token:software:totp", Provider: "GOOGLE", Enrollment: "OPTIONAL", Status: "NOT_SETUP"},
+		{FactorType: "push", Provider: "OKTA", VendorName: "OKTA", Enrollment: "REQUIRED", Status: "ACTIVE"},
+	}, factors)
+
+	_, err = d.With(oktadance.WithAPIToken("")).SupportedFactors(context.Background(), "00u1")
+	assert.Equal(t, oktadance.ErrNoAPIToken, err)
+}
@@ -0,0 +1,78 @@
+package oktadance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// WithBufferedFlowLogs holds back the Debug log lines of each
+// `Authenticate` flow, emitting them together in a single call to the
+// logger when the flow ends, whether it succeeds or fails, headed by an
+// ID for the flow. This keeps the several requests of an MFA flow
+// readable when many flows are logged at once. It has no effect without
+// `WithLogger` or `WithLeveledLogger`.
+func WithBufferedFlowLogs() Option {
+	return setupOption(func(d *Dance) {
+		d.flowLogs = true
+	})
+}
+
+// flowLog collects the log lines of a flow
+type flowLog struct {
+	mu      sync.Mutex
+	id      string
+	entries []string
+}
+
+func (f *flowLog) log(args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func (f *flowLog) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.entries, "\n")
+}
+
+// bufferLogs returns a copy of the dance which buffers its log lines,
+// if configured to, along with a func to emit them
+func (d *Dance) bufferLogs(name string) (*Dance, func()) {
+	if !d.flowLogs || d.logger == nil {
+		return d, func() {}
+	}
+
+	fl := &flowLog{id: flowID()}
+	cp := *d
+	cp.logger = &flowLogger{flow: fl, LeveledLogger: d.logger}
+	return &cp, func() {
+		if fl.String() == "" {
+			return
+		}
+		d.logger.Debug(fmt.Sprintf("%s flow %s", name, fl.id), "\n"+fl.String())
+	}
+}
+
+// flowLogger buffers the Debug lines of a flow, passing the other levels
+// straight through
+type flowLogger struct {
+	LeveledLogger
+	flow *flowLog
+}
+
+func (f *flowLogger) Debug(args ...interface{}) {
+	f.flow.log(args...)
+}
+
+// flowID gives a short random ID to tell flows apart in logs
+func flowID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,308 @@
+package oktadance
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidIDToken is returned when an id_token cannot be parsed
+// or fails verification
+var ErrInvalidIDToken = errors.New("invalid id_token")
+
+// WithVerifyIDToken enables verification of the id_token returned from
+// `AuthorizeToken` against the signing keys published by the
+// authorization server, ie `/oauth2/v1/keys`, along with its expiry,
+// issuer, and audience. As the audience is the clientID, it requires
+// `WithClientID`. Without it the claims are decoded but not trusted.
+func WithVerifyIDToken() Option {
+	return option(func(d *Dance) {
+		d.verifyIDToken = true
+	})
+}
+
+// IDTokenClaims are the commonly used claims from an Okta id_token, see
+// [ID Token](https://developer.okta.com/docs/reference/api/oidc/#id-token)
+type IDTokenClaims struct {
+	Subject           string   `json:"sub"`
+	Issuer            string   `json:"iss"`
+	Audience          string   `json:"aud"`
+	Email             string   `json:"email"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Groups            []string `json:"groups"`
+	Nonce             string   `json:"nonce"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	AuthTime          int64    `json:"auth_time"`
+	Amr               []string `json:"amr"`
+
+	// Raw holds every claim in the token, including those
+	// not modeled above
+	Raw map[string]interface{} `json:"-"`
+}
+
+// ExpiresAt is the time after which the id_token must not be accepted
+func (c *IDTokenClaims) ExpiresAt() time.Time {
+	return time.Unix(c.Expiry, 0)
+}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parseJWT splits a compact JWT into its decoded parts
+func parseJWT(token string) (jwtHeader, []byte, []byte, error) {
+	header := jwtHeader{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, fmt.Errorf("%w: expected 3 parts, found %d", ErrInvalidIDToken, len(parts))
+	}
+
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("%w: header: %v", ErrInvalidIDToken, err)
+	}
+	err = json.Unmarshal(hb, &header)
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("%w: header: %v", ErrInvalidIDToken, err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("%w: payload: %v", ErrInvalidIDToken, err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("%w: signature: %v", ErrInvalidIDToken, err)
+	}
+
+	return header, payload, sig, nil
+}
+
+// idTokenClaims decodes the claims of an id_token, verifying it
+// if so configured
+func (d *Dance) idTokenClaims(ctx context.Context, token string) (*IDTokenClaims, error) {
+	header, payload, sig, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidIDToken, err)
+	}
+	err = json.Unmarshal(payload, &claims.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidIDToken, err)
+	}
+
+	if !d.verifyIDToken {
+		return claims, nil
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidIDToken, header.Alg)
+	}
+
+	key, err := d.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := token[:strings.LastIndex(token, ".")]
+	digest := sha256.Sum256([]byte(signed))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	if d.clientID == "" {
+		return nil, fmt.Errorf("%w: audience cannot be checked without WithClientID", ErrInvalidIDToken)
+	}
+	if claims.Audience != d.clientID {
+		return nil, fmt.Errorf("%w: audience %q does not match client id", ErrInvalidIDToken, claims.Audience)
+	}
+
+	iss, err := d.issuer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != iss {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrInvalidIDToken, claims.Issuer, iss)
+	}
+
+	if d.now().After(claims.ExpiresAt()) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrInvalidIDToken, claims.ExpiresAt())
+	}
+
+	return claims, nil
+}
+
+// jwks is a JSON Web Key Set, as served from the keys endpoint
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// rsaKey converts the jwk to an RSA public key
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// DefaultJWKSCacheTTL is how long signing keys fetched from Okta are
+// reused before being fetched again, unless overridden via
+// `WithJWKSCacheTTL`
+const DefaultJWKSCacheTTL = time.Hour
+
+// minJWKSRefresh bounds how often an unknown kid may trigger a refetch
+// of the signing keys
+const minJWKSRefresh = 30 * time.Second
+
+// WithJWKSCacheTTL sets how long the signing keys used to verify
+// id_tokens are cached. Keys are always refetched when a token is signed
+// with a key not in the cache, so rotation is picked up promptly.
+func WithJWKSCacheTTL(ttl time.Duration) Option {
+	return option(func(d *Dance) {
+		d.jwksTTL = ttl
+	})
+}
+
+// keyCache holds the signing keys fetched from each keys endpoint
+type keyCache struct {
+	mu      sync.Mutex
+	entries map[string]keyCacheEntry
+}
+
+type keyCacheEntry struct {
+	keys    *jwks
+	fetched time.Time
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{entries: map[string]keyCacheEntry{}}
+}
+
+// signingKey finds the key with the given kid in Okta's key set,
+// fetching the key set if it is stale or does not contain the kid
+func (d *Dance) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	u, err := d.endpoint(ctx, "keys")
+	if err != nil {
+		return nil, err
+	}
+
+	c := d.jwks
+	c.mu.Lock()
+	entry, ok := c.entries[u]
+	c.mu.Unlock()
+
+	age := d.now().Sub(entry.fetched)
+	if ok && age < d.jwksTTL {
+		if k, found := entry.keys.find(kid); found {
+			return k.rsaKey()
+		}
+		if age < minJWKSRefresh {
+			return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+		}
+	}
+
+	// the lock is not held while fetching, so a slow fetch does not hold
+	// up verification with the keys already cached
+	keys, err := d.fetchKeys(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[u] = keyCacheEntry{keys: keys, fetched: d.now()}
+	c.mu.Unlock()
+
+	if k, found := keys.find(kid); found {
+		return k.rsaKey()
+	}
+
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+// find the key with the given kid
+func (ks *jwks) find(kid string) (jwk, bool) {
+	for _, k := range ks.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return jwk{}, false
+}
+
+// fetchKeys retrieves the signing keys for id_tokens from Okta
+func (d *Dance) fetchKeys(ctx context.Context, u string) (*jwks, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.do("Keys", req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("error fetching keys, status %d: %s", res.StatusCode, sanitizeBody(body))
+	}
+
+	keys := &jwks{}
+	err = json.Unmarshal(body, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
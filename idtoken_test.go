@@ -0,0 +1,615 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuerFor gives the issuer Okta uses for the authorization server an
+// authorize request was sent to
+func issuerFor(r *http.Request) string {
+	if parts := strings.Split(r.URL.Path, "/"); len(parts) > 3 && parts[1] == "oauth2" && parts[2] != "v1" {
+		return "https://" + r.Host + "/oauth2/" + parts[2]
+	}
+	return "https://" + r.Host
+}
+
+// authorizeHandler redirects with an id_token carrying the given claims
+// in the fragment and sets the sid cookie, as Okta does for a successful
+// authorize. The state and nonce from the request are passed back.
+func authorizeHandler(t *testing.T, signer *testSigner, claims map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		signed := map[string]interface{}{"nonce": q.Get("nonce"), "iss": issuerFor(r)}
+		for k, v := range claims {
+			signed[k] = v
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+		frag := url.Values{
+			"id_token": {signer.sign(t, signed)},
+			"state":    {q.Get("state")},
+		}
+		w.Header().Set("Location", q.Get("redirect_uri")+"#"+frag.Encode())
+		w.WriteHeader(http.StatusFound)
+	}
+}
+
+func TestDance_AuthorizeToken_Verified(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	signer := newTestSigner(t)
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	claims := map[string]interface{}{
+		"sub":    "00u123",
+		"aud":    "client",
+		"email":  "user@example.com",
+		"groups": []string{"admins"},
+		"exp":    exp.Unix(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/oauth2/v1/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	ar, err := d.AuthorizeToken(context.Background(), "token")
+	require.NoError(err)
+
+	assert.Equal(oktadance.SessionID("sid123"), ar.SessionID)
+	assert.NotEmpty(ar.IDToken)
+	assert.NotEmpty(ar.State)
+	assert.Equal(ar.Nonce, ar.Claims.Nonce)
+	assert.True(exp.Equal(ar.ExpiresAt), "expected %s, got %s", exp, ar.ExpiresAt)
+	require.NotNil(ar.Claims)
+	assert.Equal("00u123", ar.Claims.Subject)
+	assert.Equal("user@example.com", ar.Claims.Email)
+	assert.Equal([]string{"admins"}, ar.Claims.Groups)
+	assert.Equal("00u123", ar.Claims.Raw["sub"])
+}
+
+func TestDance_AuthorizeToken_BadSignature(t *testing.T) {
+	signer := newTestSigner(t)
+	imposter := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, imposter, claims))
+	mux.HandleFunc("/oauth2/v1/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	assert.True(t, errors.Is(err, oktadance.ErrInvalidIDToken), "unexpected error: %v", err)
+}
+
+func TestDance_AuthorizeToken_NowFunc(t *testing.T) {
+	signer := newTestSigner(t)
+	exp := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": exp.Unix(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/oauth2/v1/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	assert.True(t, errors.Is(err, oktadance.ErrInvalidIDToken), "unexpected error: %v", err)
+
+	before := func() time.Time { return exp.Add(-time.Minute) }
+	ar, err := d.With(oktadance.WithNowFunc(before)).AuthorizeToken(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, "00u123", ar.Claims.Subject)
+}
+
+func TestDance_AuthorizeToken_Issuer(t *testing.T) {
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"iss": "https://other.okta.com",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/oauth2/v1/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	assert.True(t, errors.Is(err, oktadance.ErrInvalidIDToken), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "issuer")
+}
+
+func TestDance_AuthorizeToken_VerifyWithoutClientID(t *testing.T) {
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "some-other-app",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/oauth2/v1/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux, oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	assert.True(t, errors.Is(err, oktadance.ErrInvalidIDToken), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "WithClientID")
+}
+
+func TestDance_KeyCache_NotLockedDuringFetch(t *testing.T) {
+	signer := newTestSigner(t)
+	rotated := newTestSigner(t)
+	rotated.kid = "rotated"
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	fetches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sessionToken") == "rotated" {
+			authorizeHandler(t, rotated, claims)(w, r)
+			return
+		}
+		authorizeHandler(t, signer, claims)(w, r)
+	})
+	mux.HandleFunc("/oauth2/v1/keys", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		n := fetches
+		mu.Unlock()
+		if n == 2 {
+			close(fetching)
+			<-release
+		}
+		signer.serveKeys(w, r)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	ctx := context.Background()
+	_, err := d.AuthorizeToken(ctx, "token")
+	require.NoError(t, err)
+
+	// a token with an unknown kid, once past the refresh floor, refetches
+	// the keys; the refetch is held up by the server
+	later := d.With(oktadance.WithNowFunc(func() time.Time { return time.Now().Add(time.Minute) }))
+	done := make(chan error, 1)
+	go func() {
+		_, err := later.AuthorizeToken(ctx, "rotated")
+		done <- err
+	}()
+	<-fetching
+
+	_, err = d.AuthorizeToken(ctx, "token")
+	require.NoError(t, err, "cached keys should be usable while a fetch is in progress")
+
+	close(release)
+	assert.Error(t, <-done)
+}
+
+func TestDance_AuthorizeToken_Unverified(t *testing.T) {
+	imposter := newTestSigner(t)
+	claims := map[string]interface{}{"sub": "00u123"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, imposter, claims))
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	ar, err := d.AuthorizeToken(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, "00u123", ar.Claims.Subject)
+}
+
+func TestDance_AuthorizeToken_KeyCache(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	current := signer
+	fetches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		authorizeHandler(t, current, claims)(w, r)
+	})
+	mux.HandleFunc("/oauth2/v1/keys", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		signer.serveKeys(w, r)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithVerifyIDToken())
+	defer srv.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := d.AuthorizeToken(ctx, "token")
+		require.NoError(err)
+	}
+	assert.Equal(1, fetches)
+
+	// a token signed with an unknown key within the refresh floor
+	// is rejected without hitting Okta again
+	rotated := newTestSigner(t)
+	rotated.kid = "rotated"
+	current = rotated
+	_, err := d.AuthorizeToken(ctx, "token")
+	assert.True(errors.Is(err, oktadance.ErrInvalidIDToken))
+	assert.Equal(1, fetches)
+}
+
+func TestDance_AuthorizeToken_KeyCacheTTL(t *testing.T) {
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	fetches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/oauth2/v1/keys", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		signer.serveKeys(w, r)
+	})
+	d, srv := mockOkta(t, mux,
+		oktadance.WithClientID("client"),
+		oktadance.WithVerifyIDToken(),
+		oktadance.WithJWKSCacheTTL(0),
+	)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := d.AuthorizeToken(context.Background(), "token")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, fetches)
+}
+
+func TestDance_AuthorizeToken_RedirectParams(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://example.com/callback?code=abc&sid=sid456&state="+r.URL.Query().Get("state"))
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	ar, err := d.AuthorizeToken(context.Background(), "token")
+	require.NoError(err)
+	assert.Equal(oktadance.SessionID("sid456"), ar.SessionID)
+	assert.Equal("abc", ar.Code)
+	assert.Nil(ar.Claims)
+}
+
+func TestDance_AuthorizeToken_RedirectError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		frag := url.Values{"error": {"access_denied"}, "error_description": {"not assigned"}, "state": {r.URL.Query().Get("state")}}
+		w.Header().Set("Location", "https://example.com/callback#"+frag.Encode())
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	var oe *oktadance.OAuthError
+	require.True(t, errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal(t, "access_denied", oe.Code)
+	assert.Equal(t, "not assigned", oe.Description)
+}
+
+func TestDance_AuthorizeToken_InteractionRequired(t *testing.T) {
+	for _, code := range []string{"login_required", "interaction_required", "consent_required"} {
+		t.Run(code, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", "https://example.com/callback#error="+code+"&state="+r.URL.Query().Get("state"))
+				w.WriteHeader(http.StatusFound)
+			})
+			d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+			defer srv.Close()
+
+			_, err := d.Authorize(context.Background(), "token")
+			assert.True(t, errors.Is(err, oktadance.ErrInteractionRequired), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestDance_AuthorizeToken_StateMismatch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://example.com/callback#sid=sid&state=forged")
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	assert.Equal(t, oktadance.ErrStateMismatch, err)
+}
+
+func TestDance_AuthorizeToken_NonceMismatch(t *testing.T) {
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{"sub": "00u123"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		q.Set("nonce", "replayed")
+		r.URL.RawQuery = q.Encode()
+		authorizeHandler(t, signer, claims)(w, r)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	_, err := d.AuthorizeToken(context.Background(), "token")
+	assert.True(t, errors.Is(err, oktadance.ErrInvalidIDToken), "unexpected error: %v", err)
+}
+
+func TestDance_Authorize_Prompt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []oktadance.Option
+		want    []string
+	}{
+		{"default", nil, []string{"none"}},
+		{"login", []oktadance.Option{oktadance.WithPrompt("login")}, []string{"login"}},
+		{"omitted", []oktadance.Option{oktadance.WithPrompt("")}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query()["prompt"]
+				http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+			})
+			d, srv := mockOkta(t, mux, append(tc.options, oktadance.WithClientID("client"))...)
+			defer srv.Close()
+
+			_, err := d.Authorize(context.Background(), "token")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDance_Authorize_SessionTokenConsumed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"errorCode":    "E0000011",
+			"errorSummary": "Invalid token provided",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	_, err := d.Authorize(context.Background(), "used")
+	assert.True(t, errors.Is(err, oktadance.ErrSessionTokenConsumed), "unexpected error: %v", err)
+}
+
+func TestDance_Authorize_Params(t *testing.T) {
+	var got url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+	})
+	d, srv := mockOkta(t, mux,
+		oktadance.WithClientID("client"),
+		oktadance.WithAuthorizeParam("idp", "0oa123"),
+		oktadance.WithAuthorizeParam("acr_values", "urn:okta:loa:2fa:any"),
+		oktadance.WithAuthorizeParam("acr_values", "phr"),
+		oktadance.WithAuthorizeParam("scope", "openid profile"),
+		oktadance.WithAuthorizeParam("state", "forged"),
+	)
+	defer srv.Close()
+
+	_, err := d.Authorize(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0oa123"}, got["idp"])
+	assert.Equal(t, []string{"urn:okta:loa:2fa:any", "phr"}, got["acr_values"])
+	assert.Equal(t, []string{"openid profile"}, got["scope"])
+	assert.Len(t, got["state"], 1)
+	assert.NotEqual(t, "forged", got.Get("state"))
+	assert.Equal(t, "client", got.Get("client_id"))
+}
+
+func TestDance_LoginHint(t *testing.T) {
+	var hint, username string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		username = body["username"]
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "SUCCESS", "sessionToken": "token"})
+	})
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		hint = r.URL.Query().Get("login_hint")
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithLoginHint("user@example.com"))
+	defer srv.Close()
+
+	token, err := d.Authenticate(context.Background(), "", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", username)
+
+	_, err = d.Authenticate(context.Background(), "other@example.com", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "other@example.com", username)
+
+	_, err = d.Authorize(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", hint)
+}
+
+func TestDance_Authorize_Success(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "cookie-sid"})
+		w.Header().Set("X-Session", "header-sid")
+		w.Header().Set("Location", "https://epithet.io/okta-callback?state="+r.URL.Query().Get("state"))
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithAuthorizeSuccess(func(res *http.Response) (oktadance.SessionID, error) {
+		if res.StatusCode != http.StatusFound {
+			return "", errors.New("not authorized")
+		}
+		return oktadance.SessionID(res.Header.Get("X-Session")), nil
+	}))
+	defer srv.Close()
+
+	sid, err := d.Authorize(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionID("header-sid"), sid)
+}
+
+func TestDance_Authorize_NoSessionCookie(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://epithet.io/okta-callback?state="+r.URL.Query().Get("state"))
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	_, err := d.Authorize(context.Background(), "token")
+	assert.Equal(t, oktadance.ErrNoSessionCookie, err)
+}
+
+func TestDance_AuthorizeToken_AuthorizationServer(t *testing.T) {
+	signer := newTestSigner(t)
+	claims := map[string]interface{}{
+		"sub": "00u123",
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/aus123/v1/authorize", authorizeHandler(t, signer, claims))
+	mux.HandleFunc("/oauth2/aus123/v1/keys", signer.serveKeys)
+	d, srv := mockOkta(t, mux,
+		oktadance.WithClientID("client"),
+		oktadance.WithVerifyIDToken(),
+		oktadance.WithAuthorizationServer("aus123"),
+	)
+	defer srv.Close()
+
+	ar, err := d.AuthorizeToken(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, "00u123", ar.Claims.Subject)
+}
+
+func TestDance_Authorize_StateGenerator(t *testing.T) {
+	var state, nonce string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		state = r.URL.Query().Get("state")
+		nonce = r.URL.Query().Get("nonce")
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+		w.Header().Set("Location", r.URL.Query().Get("redirect_uri"))
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux,
+		oktadance.WithClientID("client"),
+		oktadance.WithStateGenerator(func() (string, error) { return "fixed", nil }),
+	)
+	defer srv.Close()
+
+	ar, err := d.AuthorizeToken(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, "fixed", state)
+	assert.Equal(t, "fixed", nonce)
+	assert.Equal(t, "fixed", ar.State)
+
+	d = d.With(oktadance.WithStateGenerator(func() (string, error) { return "", errors.New("no entropy") }))
+	_, err = d.AuthorizeToken(context.Background(), "token")
+	assert.EqualError(t, err, "no entropy")
+}
+
+func TestDance_Authorize_RedirectHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+		w.Header().Set("Location", "/hop?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		w.Header().Set("Location", q.Get("redirect_uri")+"?state="+url.QueryEscape(q.Get("state")))
+		w.WriteHeader(http.StatusFound)
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	hops := 0
+	d := oktadance.New(host,
+		oktadance.WithClientID("client"),
+		oktadance.WithInsecureSkipVerify(),
+		oktadance.WithRedirectHandler(func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host != host {
+				return http.ErrUseLastResponse
+			}
+			hops++
+			return nil
+		}),
+	)
+
+	ar, err := d.AuthorizeToken(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, 1, hops)
+	assert.Equal(t, oktadance.SessionID("sid123"), ar.SessionID)
+
+	d = oktadance.New(host,
+		oktadance.WithHTTPClient(srv.Client()),
+		oktadance.WithRedirectHandler(func(*http.Request, []*http.Request) error { return nil }),
+	)
+	_, err = d.AuthorizeToken(context.Background(), "token")
+	assert.True(t, errors.Is(err, oktadance.ErrHTTPClientConflict), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "WithRedirectHandler")
+}
@@ -3,13 +3,18 @@ package oktadance
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -19,38 +24,281 @@ type SessionToken string
 // SessionID is an OKTA sessionId or sid
 type SessionID string
 
+var (
+	// ErrEmptySessionToken is returned when an empty SessionToken
+	// is used or (un)marshalled
+	ErrEmptySessionToken = errors.New("empty sessionToken")
+
+	// ErrEmptySessionID is returned when an empty SessionID
+	// is used or (un)marshalled
+	ErrEmptySessionID = errors.New("empty sessionId")
+
+	// ErrEmptyStateToken is returned when an empty stateToken is
+	// given to resume an authn transaction
+	ErrEmptyStateToken = errors.New("empty stateToken")
+)
+
+func (t SessionToken) String() string { return string(t) }
+
+// Valid reports whether the SessionToken is usable, ie not empty
+func (t SessionToken) Valid() bool { return t != "" }
+
+// MarshalJSON marshals the SessionToken as a JSON string, refusing
+// to persist an empty one
+func (t SessionToken) MarshalJSON() ([]byte, error) {
+	if !t.Valid() {
+		return nil, ErrEmptySessionToken
+	}
+	return json.Marshal(string(t))
+}
+
+// UnmarshalJSON unmarshals a SessionToken from a JSON string,
+// rejecting an empty one
+func (t *SessionToken) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		return ErrEmptySessionToken
+	}
+	*t = SessionToken(s)
+	return nil
+}
+
+func (s SessionID) String() string { return string(s) }
+
+// Valid reports whether the SessionID is usable, ie not empty
+func (s SessionID) Valid() bool { return s != "" }
+
+// MarshalJSON marshals the SessionID as a JSON string, refusing
+// to persist an empty one
+func (s SessionID) MarshalJSON() ([]byte, error) {
+	if !s.Valid() {
+		return nil, ErrEmptySessionID
+	}
+	return json.Marshal(string(s))
+}
+
+// UnmarshalJSON unmarshals a SessionID from a JSON string,
+// rejecting an empty one
+func (s *SessionID) UnmarshalJSON(b []byte) error {
+	var v string
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	if v == "" {
+		return ErrEmptySessionID
+	}
+	*s = SessionID(v)
+	return nil
+}
+
+// sessionCookieName is the name of the cookie Okta uses to carry the SessionID
+const sessionCookieName = "sid"
+
+// SessionIDFromCookie extracts the SessionID from an Okta `sid` cookie.
+// It returns an empty SessionID if the cookie is nil or is not a `sid` cookie.
+func SessionIDFromCookie(c *http.Cookie) SessionID {
+	if c == nil || c.Name != sessionCookieName {
+		return ""
+	}
+	return SessionID(c.Value)
+}
+
+// Cookie builds the `sid` cookie used to present this SessionID to Okta
+func (s SessionID) Cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:  sessionCookieName,
+		Value: string(s),
+	}
+}
+
 // Dance performs the authentication & authorization dance
 // with Okta
+//
+// A Dance is safe for concurrent use by multiple goroutines. Its
+// configuration is fixed once `New` returns, and any state it
+// accumulates afterwards is guarded internally.
 type Dance struct {
 	httpClient *http.Client
 	appID      string
 	oktaDomain string
 	clientID   string
-	logger     func(...interface{})
+	logger     LeveledLogger
 	prettyJSON bool
+	userAgent  string
+
+	apiToken          string
+	cookieJar         http.CookieJar
+	interceptor       func(string, *http.Response)
+	defaultHeaders    http.Header
+	defaultTimeout    time.Duration
+	raceFactors       bool
+	factorPrefs       []FactorPreference
+	prompt            string
+	authServer        string
+	closedSessionOK   bool
+	requestIDHeader   string
+	requestIDKey      interface{}
+	watchInterval     time.Duration
+	deviceFingerprint string
+	forwardedFor      string
+	maxCodeAttempts   int
+	stateGenerator    func() (string, error)
+	maxResponseBytes  int64
+	pollBackoff       BackoffFunc
+	pollJitter        float64
+
+	baseHTTPClient     *http.Client
+	insecureSkipVerify bool
+	ownsHTTPClient     bool
+	clientStale        bool
+	redirectHandler    func(*http.Request, []*http.Request) error
+	authnFlights       *authnFlights
+	clientSecret       string
+	clientAuthMethod   ClientAuthMethod
+	retryPolicy        RetryPolicy
+	requestSlots       semaphore
+	now                func() time.Time
+	after              func(time.Duration) <-chan time.Time
+	flowLogs           bool
+	transportWrapper   func(http.RoundTripper) http.RoundTripper
+	authorizeParams    url.Values
+	loginHint          string
+	authorizeSuccess   func(*http.Response) (SessionID, error)
+
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+
+	// err is a configuration error, returned by every request
+	err error
+
+	verifyIDToken bool
+	jwksTTL       time.Duration
+	jwks          *keyCache
+
+	discovery bool
+	metadata  *metadataCache
 }
 
 // New dance client. If you need to use `Authenticate` make sure to
 // pass in a clientID option via `WithClientID`
 func New(oktaDomain string, options ...Option) *Dance {
 	d := &Dance{
-		oktaDomain: oktaDomain,
-		logger:     nil,
+		oktaDomain:       oktaDomain,
+		logger:           nil,
+		userAgent:        DefaultUserAgent,
+		prompt:           "none",
+		pollBackoff:      DefaultPollBackoff,
+		pollJitter:       DefaultPollJitter,
+		watchInterval:    DefaultSessionWatchInterval,
+		maxCodeAttempts:  DefaultMaxCodeAttempts,
+		stateGenerator:   randomString,
+		maxResponseBytes: DefaultMaxResponseBytes,
+		requestSlots:     newSemaphore(DefaultMaxConcurrentRequests),
+		now:              time.Now,
+		after:            time.After,
+		jwksTTL:          DefaultJWKSCacheTTL,
+		jwks:             newKeyCache(),
+		metadata:         newMetadataCache(),
 	}
 
 	for _, o := range options {
 		o.apply(d)
 	}
+	d.buildHTTPClient()
+
+	return d
+}
+
+// buildHTTPClient sets up the http client from the client options,
+// recording any conflict between them in `d.err`
+func (d *Dance) buildHTTPClient() {
+	d.clientStale = false
+	d.err = nil
+
+	if hc := d.baseHTTPClient; hc != nil {
+		if option := d.httpClientConflict(); option != "" {
+			d.err = fmt.Errorf("%s: %w", option, ErrHTTPClientConflict)
+		}
+		d.httpClient = hc
+		d.ownsHTTPClient = false
+		d.wrapTransport()
+		return
+	}
 
-	if d.httpClient == nil {
-		d.httpClient = &http.Client{
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
+	redirect := noRedirects
+	if d.redirectHandler != nil {
+		redirect = checkRedirect(d.redirectHandler)
+	}
+	d.httpClient = &http.Client{
+		CheckRedirect: redirect,
+	}
+	d.ownsHTTPClient = true
+	if d.insecureSkipVerify || d.hasTransportTimeouts() {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if d.insecureSkipVerify {
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 		}
+		d.applyTransportTimeouts(t)
+		d.httpClient.Transport = t
 	}
+	d.wrapTransport()
+}
 
-	return d
+// httpClientConflict names the first option found which would have to
+// modify a client given via `WithHTTPClient`, if any
+func (d *Dance) httpClientConflict() string {
+	switch {
+	case d.insecureSkipVerify:
+		return "WithInsecureSkipVerify"
+	case d.redirectHandler != nil:
+		return "WithRedirectHandler"
+	case d.dialTimeout > 0:
+		return "WithDialTimeout"
+	case d.tlsHandshakeTimeout > 0:
+		return "WithTLSHandshakeTimeout"
+	case d.responseHeaderTimeout > 0:
+		return "WithResponseHeaderTimeout"
+	}
+	return ""
+}
+
+// Close releases the idle connections held by the dance's http client.
+// A client given via `WithHTTPClient` is left alone, as it belongs to the
+// caller. The dance remains usable, opening new connections as needed.
+func (d *Dance) Close() error {
+	if d.ownsHTTPClient {
+		d.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
+// With returns a copy of the dance with the options applied, leaving
+// the original untouched. The copy shares the http client and any
+// caches with the original unless the options replace them. Options
+// which configure the http client, such as `WithHTTPClient`,
+// `WithInsecureSkipVerify`, `WithTransportWrapper`, or the timeouts,
+// give the copy a client of its own. This is handy for tools managing
+// several Okta orgs or Apps:
+// ```
+// base := oktadance.New("example.okta.com", oktadance.WithLogger(log.Println))
+// preview := base.With(oktadance.WithDomain("example.oktapreview.com"))
+// ```
+func (d *Dance) With(options ...Option) *Dance {
+	cp := *d
+	cp.clientStale = false
+	for _, o := range options {
+		o.apply(&cp)
+	}
+	if cp.clientStale {
+		cp.buildHTTPClient()
+	}
+	return &cp
 }
 
 // Option configures the dance
@@ -64,6 +312,31 @@ func (f option) apply(a *Dance) {
 	f(a)
 }
 
+// clientOption configures the http client, which is rebuilt once the
+// options have been applied. It cannot be given per call.
+type clientOption func(*Dance)
+
+func (f clientOption) apply(a *Dance) {
+	f(a)
+	a.clientStale = true
+}
+
+// setupOption configures something done before the per call options are
+// applied, so it cannot be given per call
+type setupOption func(*Dance)
+
+func (f setupOption) apply(a *Dance) {
+	f(a)
+}
+
+// WithDomain sets the Okta domain, overriding the one given to `New`.
+// This is mostly useful with `Dance.With`.
+func WithDomain(oktaDomain string) Option {
+	return option(func(d *Dance) {
+		d.oktaDomain = oktaDomain
+	})
+}
+
 // WithClientID configures a clientID on the dance. This is needed for
 // some operations. Those operations call it out. If all you are doing
 // is authenticating, you should not need the client_id
@@ -84,193 +357,1104 @@ func WithClientID(clientID string) Option {
 // }
 // ```
 func WithHTTPClient(hc *http.Client) Option {
+	return clientOption(func(d *Dance) {
+		d.baseHTTPClient = hc
+	})
+}
+
+// ErrHTTPClientConflict is returned by every request of a dance given
+// `WithHTTPClient` along with an option which would have to modify that
+// client, ie `WithInsecureSkipVerify`, `WithRedirectHandler`, or a
+// transport timeout. The error names the option.
+var ErrHTTPClientConflict = errors.New("option cannot be used with WithHTTPClient")
+
+// WithInsecureSkipVerify disables TLS certificate verification on the
+// default http client, for testing against a local proxy or mock Okta
+// with a self-signed certificate.
+//
+// WARNING: this makes the connection to Okta vulnerable to interception,
+// exposing passwords and session tokens. NEVER use it against a real
+// Okta org. It may not be combined with `WithHTTPClient`.
+func WithInsecureSkipVerify() Option {
+	return clientOption(func(d *Dance) {
+		d.insecureSkipVerify = true
+	})
+}
+
+// WithDefaultHeaders adds headers to every request sent to Okta, ie
+// `X-Forwarded-Host` or a key required by an API gateway in front of
+// Okta. Headers set by the library itself, such as `Content-Type` and
+// `Accept`, are never overridden.
+func WithDefaultHeaders(headers http.Header) Option {
+	return option(func(d *Dance) {
+		d.defaultHeaders = http.Header{}
+		for k, vs := range headers {
+			d.defaultHeaders[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+		}
+	})
+}
+
+// WithCookieJar attaches a cookie jar which will hold every cookie Okta
+// sets during the dance (ie `sid`, `DT`, `JSESSIONID`) and present them on
+// subsequent requests, as a browser would. This preserves device trust
+// across calls. Cookies passed explicitly, such as the sid given to
+// `Session`, take precedence over those in the jar.
+func WithCookieJar(jar http.CookieJar) Option {
+	return option(func(d *Dance) {
+		d.cookieJar = jar
+	})
+}
+
+// WithResponseInterceptor registers a function which is handed every
+// response from Okta, along with the name of the operation (ie
+// "Authenticate") which made the request. This is an escape hatch for
+// headers, cookies, or fields the library does not model. The response
+// is a copy with its own body, so the interceptor may read it freely,
+// but it must not retain the response past the call.
+func WithResponseInterceptor(interceptor func(op string, res *http.Response)) Option {
+	return option(func(d *Dance) {
+		d.interceptor = interceptor
+	})
+}
+
+// WithLogger passes in a logging function, such as `log.Println`,
+// which will be used to log communication with Okta. Every level is
+// logged; use `WithLeveledLogger` to tell them apart.
+func WithLogger(log func(...interface{})) Option {
+	return option(func(d *Dance) {
+		d.logger = nil
+		if log != nil {
+			d.logger = funcLogger(log)
+		}
+	})
+}
+
+// WithPrettyJSON pretty prints JSON bodies in logs. The bodies sent
+// to and received from Okta are left untouched.
+func WithPrettyJSON() Option {
+	return option(func(d *Dance) {
+		d.prettyJSON = true
+	})
+}
+
+// ErrNoAPIToken is returned from methods which use the Okta management
+// API when no API token has been configured
+var ErrNoAPIToken = errors.New("an API token is required, see WithAPIToken")
+
+// WithAPIToken configures an Okta API token, which is needed for the
+// administrative operations such as `ListFactors`. Those operations
+// call it out. The token grants the privileges of the admin who created
+// it, so guard it carefully.
+func WithAPIToken(token string) Option {
+	return option(func(d *Dance) {
+		d.apiToken = token
+	})
+}
+
+// WithDefaultTimeout bounds each call made with a context that has no
+// deadline of its own. A deadline already present on the context is
+// always respected. Note that for `Authenticate` the timeout covers the
+// whole exchange, including waiting on the user to complete MFA.
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return option(func(d *Dance) {
+		d.defaultTimeout = timeout
+	})
+}
+
+// ErrNotPerCallOption is returned by a call given, via `ContextWithOptions`
+// or `AuthnRequest.Options`, an option which can only configure a whole
+// dance
+var ErrNotPerCallOption = errors.New("option cannot be given per call, use New or Dance.With")
+
+// ContextWithOptions returns a context carrying options which override
+// the dance's configuration for any call made with it, ie to use a
+// different timeout or prompt for a single request without creating a
+// new `Dance`. Options already carried by ctx are applied first.
+//
+// Options which configure the http client, `WithHTTPClient`,
+// `WithInsecureSkipVerify`, `WithRedirectHandler`, `WithTransportWrapper`,
+// `WithMaxConcurrentRequests`, and the transport timeouts, and those
+// which act before the call starts, `WithAuthnDedup` and
+// `WithBufferedFlowLogs`, cannot be given per call. A call given any of
+// them fails with `ErrNotPerCallOption`; use `Dance.With` instead.
+func ContextWithOptions(ctx context.Context, options ...Option) context.Context {
+	prev, _ := ctx.Value(callOptionsKey{}).([]Option)
+	opts := make([]Option, 0, len(prev)+len(options))
+	opts = append(opts, prev...)
+	opts = append(opts, options...)
+	return context.WithValue(ctx, callOptionsKey{}, opts)
+}
+
+type callOptionsKey struct{}
+
+// call prepares a public method: it applies any options carried by ctx,
+// see `ContextWithOptions`, and then the default timeout
+func (d *Dance) call(ctx context.Context) (*Dance, context.Context, context.CancelFunc) {
+	d = d.withCallOptions(ctx)
+	ctx, cancel := d.context(ctx)
+	return d, ctx, cancel
+}
+
+// withCallOptions applies any options carried by ctx. If one of them
+// cannot be given per call, the copy fails every request with
+// `ErrNotPerCallOption`.
+func (d *Dance) withCallOptions(ctx context.Context) *Dance {
+	opts, ok := ctx.Value(callOptionsKey{}).([]Option)
+	if !ok || len(opts) == 0 {
+		return d
+	}
+	if !perCall(opts) {
+		cp := *d
+		cp.err = ErrNotPerCallOption
+		return &cp
+	}
+	return d.With(opts...)
+}
+
+// perCall reports whether all of opts may be given for a single call
+func perCall(opts []Option) bool {
+	for _, o := range opts {
+		switch o.(type) {
+		case clientOption, setupOption:
+			return false
+		}
+	}
+	return true
+}
+
+// context applies the default timeout to ctx if it has no deadline
+func (d *Dance) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.defaultTimeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.defaultTimeout)
+}
+
+// WithNowFunc replaces the clock used wherever the dance compares times,
+// ie the expiry of id_tokens and cached signing keys, the time elapsed
+// waiting on a push, and session expiry in `WatchSession` and
+// `Dance.IsSessionExpired`. This is mostly useful in tests. Waits, ie
+// between polls, still take real time; see `WithClock` to replace those
+// too.
+func WithNowFunc(now func() time.Time) Option {
+	return option(func(d *Dance) {
+		d.now = now
+	})
+}
+
+// Clock is the source of time for a dance, see `WithClock`
+type Clock interface {
+	// Now gives the current time, as `time.Now`
+	Now() time.Time
+
+	// After waits for the duration to pass, as `time.After`
+	After(d time.Duration) <-chan time.Time
+}
+
+// WithClock replaces the clock, as `WithNowFunc` does, and also the
+// waits between polls of a push or device authorization, between
+// retries, and between the checks of `WatchSession`, so tests can run
+// them without taking real time
+func WithClock(clock Clock) Option {
+	return option(func(d *Dance) {
+		d.now = clock.Now
+		d.after = clock.After
+	})
+}
+
+// DefaultUserAgent is the User-Agent sent to Okta unless
+// overridden via `WithUserAgent`
+const DefaultUserAgent = "oktadance/0.1"
+
+// WithUserAgent sets the User-Agent header sent on every request
+// to Okta. This makes the client identifiable in Okta's system log.
+func WithUserAgent(userAgent string) Option {
+	return option(func(d *Dance) {
+		d.userAgent = userAgent
+	})
+}
+
+// Authenticate authenticates the user against Okta and returns a `sessionToken`.
+// The sessionToken needs to be given to the App which will then use `Authenticate`
+// to authenticate the user for that App. The sessionToken is only usable once.
+//
+// The `Multifactor` argument is used to complete multifactor authentication, if needed.
+// If you *know* you won't need m,ultifactor authentication, it may be nil.
+// Any options override the Dance's configuration for this call only, as
+// with `AuthnRequest.Options`.
+func (d *Dance) Authenticate(ctx context.Context, username, password string, mfa Multifactor, options ...Option) (SessionToken, error) {
+	ar, err := d.AuthenticateWith(ctx, AuthnRequest{
+		Username:    username,
+		Password:    password,
+		Multifactor: mfa,
+		Options:     options,
+	})
+	if err != nil {
+		return "", err
+	}
+	return ar.SessionToken, nil
+}
+
+// AuthnRequest holds everything needed to authenticate a user
+// via `AuthenticateWith`
+type AuthnRequest struct {
+	Username string
+	Password string
+
+	// Multifactor is used to complete multifactor authentication, if
+	// needed. It may be nil if you *know* it won't be needed.
+	Multifactor Multifactor
+
+	// DeviceToken identifies the device the user is logging in from,
+	// allowing Okta to recognize it across logins. Optional.
+	DeviceToken string
+
+	// Audience is the App the sessionToken is intended for. Optional.
+	Audience string
+
+	// MultiOptionalFactorEnroll asks Okta to offer enrollment of
+	// optional factors, not just required ones
+	MultiOptionalFactorEnroll bool
+
+	// WarnBeforePasswordExpired asks Okta to report when the password
+	// is about to expire. The login still succeeds, with the warning
+	// reported in `AuthnResult.PasswordExpiresSoon`.
+	WarnBeforePasswordExpired bool
+
+	// Options override the Dance's configuration for this request only.
+	// As with `ContextWithOptions`, options which configure the http
+	// client or act before the call starts fail with `ErrNotPerCallOption`.
+	Options []Option
+}
+
+// AuthnResult is the outcome of a successful `AuthenticateWith`
+type AuthnResult struct {
+	// SessionToken is the single use token to give to `Authorize`
+	SessionToken SessionToken
+
+	// PasswordExpiresSoon is set when the user's password is about
+	// to expire. Okta only reports this if it was requested via
+	// `AuthnRequest.WarnBeforePasswordExpired`.
+	PasswordExpiresSoon bool
+
+	// PasswordExpiresAt is when the password will expire, if
+	// PasswordExpiresSoon is set. Okta reports this to the day.
+	PasswordExpiresAt time.Time
+
+	// Factor is the factor used to complete MFA, nil if Okta did
+	// not require MFA
+	Factor Factor
+
+	// AuthMethods are the methods used to authenticate, as they would
+	// appear in `amr`, ie `AuthMethodPassword` alone when Okta accepted
+	// the password without MFA. Callers enforcing step up can check this
+	// before `Authorize`.
+	AuthMethods []AuthMethod
+}
+
+// AuthenticateWith authenticates the user as `Authenticate` does,
+// taking the details of the request as an `AuthnRequest`.
+//
+// A password which is about to expire does not prevent login; the
+// warning is skipped and reported on the `AuthnResult` so the caller
+// can prompt the user to change it.
+func (d *Dance) AuthenticateWith(ctx context.Context, request AuthnRequest) (*AuthnResult, error) {
+	if len(request.Options) > 0 {
+		if !perCall(request.Options) {
+			return nil, ErrNotPerCallOption
+		}
+		d = d.With(request.Options...)
+	}
+	d, flush := d.bufferLogs("Authenticate")
+	defer flush()
+
+	if d.authnFlights != nil && dedupable(ctx, request) {
+		return d.authnFlights.do(d.authnFlightKey(request), func() (*AuthnResult, error) {
+			return d.authenticate(ctx, request)
+		})
+	}
+	return d.authenticate(ctx, request)
+}
+
+// authenticate performs the authn transaction for `AuthenticateWith`
+func (d *Dance) authenticate(ctx context.Context, request AuthnRequest) (*AuthnResult, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	mfa := request.Multifactor
+	authn := oktaAuthnRequest{
+		Username: request.Username,
+		Password: request.Password,
+		Audience: request.Audience,
+	}
+	if authn.Username == "" {
+		authn.Username = d.loginHint
+	}
+	if request.DeviceToken != "" {
+		authn.Context = &oktaAuthnContext{DeviceToken: request.DeviceToken}
+	}
+	if request.MultiOptionalFactorEnroll || request.WarnBeforePasswordExpired {
+		authn.Options = &oktaAuthnOptions{
+			MultiOptionalFactorEnroll: request.MultiOptionalFactorEnroll,
+			WarnBeforePasswordExpired: request.WarnBeforePasswordExpired,
+		}
+	}
+
+	body, err := json.Marshal(authn)
+	if err != nil {
+		return nil, err
+	}
+
+	ar, err := d.authnStep(ctx, "Authenticate", fmt.Sprintf("https://%s/api/v1/authn", d.oktaDomain), body)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.continueAuthn(ctx, ar, mfa)
+}
+
+// ResumeAuthn continues an authn transaction from its stateToken, ie one
+// persisted by a process which crashed part way through MFA, or handed
+// between processes orchestrating a login, without asking for the
+// username and password again. The transaction is fetched in its current
+// state and completed as `Authenticate` would, with a challenge which was
+// already issued, such as a push, being returned to factor selection and
+// issued anew. The transaction must not have expired.
+func (d *Dance) ResumeAuthn(ctx context.Context, stateToken string, mfa Multifactor) (SessionToken, error) {
+	if stateToken == "" {
+		return "", ErrEmptyStateToken
+	}
+
+	d, flush := d.bufferLogs("ResumeAuthn")
+	defer flush()
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	ar, err := d.fetchAuthn(ctx, "ResumeAuthn", stateToken)
+	if err != nil {
+		return "", err
+	}
+
+	if ar.Status == StatusMFAChallenge {
+		ar, err = d.previous(ctx, ar)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	result, err := d.continueAuthn(ctx, ar, mfa)
+	if err != nil {
+		return "", err
+	}
+	return result.SessionToken, nil
+}
+
+// AuthnState is the current state of an authn transaction, as given by
+// `Dance.AuthnState`
+type AuthnState struct {
+	Status     AuthnStatus
+	StateToken string
+
+	// ExpiresAt is when the transaction expires, zero if Okta did not say
+	ExpiresAt time.Time
+
+	// Factors are those the user may choose from, in the `MFA_REQUIRED`
+	// state
+	Factors []Factor
+
+	// Factor is the factor being verified in the `MFA_CHALLENGE` state,
+	// nil otherwise
+	Factor Factor
+
+	// FactorResult is the outcome of verifying Factor, ie `WAITING`
+	FactorResult FactorResult
+
+	// SessionToken, once Status is `SUCCESS`
+	SessionToken SessionToken
+
+	// Links are the endpoints Okta gave for the next steps, by name
+	Links map[string]string
+}
+
+// AuthnState fetches the current state of an authn transaction without
+// advancing it, ie to see which factors the user may choose from, or
+// whether a push is still waiting. See `ResumeAuthn` to complete it.
+func (d *Dance) AuthnState(ctx context.Context, stateToken string) (*AuthnState, error) {
+	if stateToken == "" {
+		return nil, ErrEmptyStateToken
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	ar, err := d.fetchAuthn(ctx, "AuthnState", stateToken)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &AuthnState{
+		Status:       ar.Status,
+		StateToken:   ar.StateToken,
+		Factors:      ar.Embedded.factors(),
+		FactorResult: ar.FactorResult,
+		SessionToken: SessionToken(ar.SessionToken),
+		Links:        ar.Links.links(),
+	}
+	if expiresAt, ok := ar.expiresAt(); ok {
+		state.ExpiresAt = expiresAt
+	}
+	if ar.Embedded.Factor.ID != "" {
+		state.Factor = ar.Embedded.Factor.factor()
+	}
+	return state, nil
+}
+
+// fetchAuthn fetches the current state of an authn transaction, without
+// advancing it
+func (d *Dance) fetchAuthn(ctx context.Context, name, stateToken string) (oktaUserAuthn, error) {
+	body, err := json.Marshal(map[string]string{"stateToken": stateToken})
+	if err != nil {
+		return oktaUserAuthn{}, err
+	}
+
+	return d.authnStep(ctx, name, fmt.Sprintf("https://%s/api/v1/authn", d.oktaDomain), body)
+}
+
+// continueAuthn completes an authn transaction from the given state,
+// performing MFA as needed
+func (d *Dance) continueAuthn(ctx context.Context, ar oktaUserAuthn, mfa Multifactor) (*AuthnResult, error) {
+	var err error
+	result := &AuthnResult{}
+	for {
+		switch ar.Status {
+		case StatusPasswordWarn:
+			result.PasswordExpiresSoon = true
+			days := ar.Embedded.Policy.Expiration.PasswordExpireDays
+			result.PasswordExpiresAt = d.now().AddDate(0, 0, days)
+			ar, err = d.skip(ctx, ar)
+			if err != nil {
+				return nil, err
+			}
+			continue
+
+		case StatusMFARequired:
+			if teo, ok := mfa.(TransactionExpiryObserver); ok {
+				if expiresAt, ok := ar.expiresAt(); ok {
+					teo.TransactionExpiresAt(expiresAt)
+				}
+			}
+
+			var factor Factor
+			if len(ar.Embedded.Factors) == 1 {
+				factor = ar.Embedded.Factors[0].factor()
+			} else if len(ar.Embedded.Factors) == 0 {
+				return nil, ErrNoFactorsAvailable
+			} else {
+				factors := ar.Embedded.factors()
+				factor = SelectFactor(factors, d.factorPrefs...)
+				if pushes := pushFactors(factors); factor == nil && d.raceFactors && len(pushes) > 1 {
+					result.SessionToken, result.Factor, err = raceFactors(ctx, d, mfa, ar.StateToken, pushes)
+					if err != nil {
+						d.abandon(ctx, ar.StateToken)
+						return nil, err
+					}
+					result.AuthMethods = authMethods(result.Factor)
+					return result, nil
+				}
+				if factor == nil {
+					if mfa == nil {
+						d.abandon(ctx, ar.StateToken)
+						return nil, ErrMFARequiredButNoHandler
+					}
+					factor, err = mfa.Select(factors)
+					if err != nil {
+						return nil, fmt.Errorf("error selecting MFA factor: %w", err)
+					}
+					if factor == nil {
+						return nil, errors.New("no MFA was factor selected")
+					}
+					factor = offeredFactor(factors, factor)
+					if factor == nil {
+						return nil, errors.New("a factor was returned which was not passed in")
+					}
+				}
+			}
+
+			if factor == nil {
+				return nil, errors.New("MFA required but no factor selected")
+			}
+			// only a push can be completed without a Multifactor, whether the
+			// factor was the only one offered or picked by a preference
+			if _, ok := factor.(pushFactor); !ok && mfa == nil {
+				d.abandon(ctx, ar.StateToken)
+				return nil, fmt.Errorf("MFA factor %s: %w", factor.FactorType(), ErrMFARequiredButNoHandler)
+			}
+
+			result.SessionToken, err = factor.perform(ctx, d, mfa, ar.StateToken)
+			if err != nil {
+				d.abandon(ctx, ar.StateToken)
+				return nil, err
+			}
+			result.Factor = factor
+			result.AuthMethods = authMethods(factor)
+			return result, nil
+
+		case StatusMFAEnroll:
+			return nil, ErrNoFactorsEnrolled
+
+		case StatusLockedOut, StatusPasswordExpired, StatusPasswordReset:
+			return nil, &RecoveryError{
+				Status:     ar.Status,
+				StateToken: ar.StateToken,
+				Links:      ar.Links.links(),
+			}
+
+		case StatusSuccess:
+			result.SessionToken = SessionToken(ar.SessionToken)
+			result.AuthMethods = authMethods(nil)
+			return result, nil
+
+		default:
+			return nil, fmt.Errorf("Status: %s", ar.Status)
+		}
+	}
+}
+
+// WithDeviceFingerprint sends the `X-Device-Fingerprint` header with the
+// authn requests made by `Authenticate`, tying the login to a device so
+// Okta can, ie, notify the user of sign ins from new devices. Along with
+// `AuthnRequest.DeviceToken` this lets adaptive policies skip MFA on known
+// devices. The fingerprint should be stable for the device and opaque,
+// such as a hash of `id:timestamp:salt` as produced by Okta's
+// fingerprinting library.
+func WithDeviceFingerprint(fingerprint string) Option {
+	return option(func(d *Dance) {
+		d.deviceFingerprint = fingerprint
+	})
+}
+
+// WithForwardedFor sends the `X-Forwarded-For` header with authn requests,
+// for servers which authenticate on behalf of a user, so Okta's network
+// zones, adaptive MFA, and ThreatInsight evaluate the user's IP rather
+// than the server's. As the IP differs per user it is usually given per
+// call, via `Dance.With` or `ContextWithOptions`.
+//
+// Okta ignores the header unless the server's IP is configured as a
+// trusted proxy in the org's network zones.
+func WithForwardedFor(ip string) Option {
+	return option(func(d *Dance) {
+		d.forwardedFor = ip
+	})
+}
+
+// CancelAuthn cancels an in progress authn transaction, such as one
+// waiting on a push, so Okta can clean up its state. `Authenticate`
+// does this itself, on a best effort basis, if its context is done
+// while waiting on MFA.
+func (d *Dance) CancelAuthn(ctx context.Context, stateToken string) error {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"stateToken": stateToken})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.authnStep(ctx, "CancelAuthn", fmt.Sprintf("https://%s/api/v1/authn/cancel", d.oktaDomain), body)
+	return err
+}
+
+// abandonTimeout bounds the best effort cancellation of an
+// abandoned authn transaction
+const abandonTimeout = 5 * time.Second
+
+// abandon cancels the authn transaction if it is being given up on
+// because the context is done
+func (d *Dance) abandon(ctx context.Context, stateToken string) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), abandonTimeout)
+	defer cancel()
+	err := d.CancelAuthn(ctx, stateToken)
+	if err != nil && d.logger != nil {
+		d.logger.Error("CancelAuthn", "failed to cancel abandoned authn transaction:", err)
+	}
+}
+
+// skip moves past an optional step of an authn transaction, such
+// as a PASSWORD_WARN
+func (d *Dance) skip(ctx context.Context, ar oktaUserAuthn) (oktaUserAuthn, error) {
+	u := ar.Links.Skip.Href
+	if u == "" {
+		u = fmt.Sprintf("https://%s/api/v1/authn/lifecycle/skip", d.oktaDomain)
+	}
+
+	body, err := json.Marshal(map[string]string{"stateToken": ar.StateToken})
+	if err != nil {
+		return oktaUserAuthn{}, err
+	}
+
+	return d.authnStep(ctx, "Skip", u, body)
+}
+
+// previous moves an authn transaction back a step, such as from an
+// MFA_CHALLENGE to MFA_REQUIRED to select a factor again
+func (d *Dance) previous(ctx context.Context, ar oktaUserAuthn) (oktaUserAuthn, error) {
+	u := ar.Links.Prev.Href
+	if u == "" {
+		u = fmt.Sprintf("https://%s/api/v1/authn/previous", d.oktaDomain)
+	}
+
+	body, err := json.Marshal(map[string]string{"stateToken": ar.StateToken})
+	if err != nil {
+		return oktaUserAuthn{}, err
+	}
+
+	return d.authnStep(ctx, "Previous", u, body)
+}
+
+// authnStep posts a JSON body to an authn endpoint, returning the
+// resulting state of the authn transaction. Failures reported by
+// Okta are returned as an `*OktaError`.
+func (d *Dance) authnStep(ctx context.Context, name, u string, body []byte) (oktaUserAuthn, error) {
+	ar := oktaUserAuthn{}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return ar, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if d.deviceFingerprint != "" {
+		req.Header.Set("X-Device-Fingerprint", d.deviceFingerprint)
+	}
+	if d.forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", d.forwardedFor)
+	}
+
+	res, err := d.do(name, req.WithContext(ctx))
+	if err != nil {
+		return ar, err
+	}
+	defer res.Body.Close()
+
+	rb, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ar, err
+	}
+	err = checkJSON(res, rb)
+	if err != nil {
+		return ar, err
+	}
+
+	if res.StatusCode >= 400 {
+		return ar, oktaError(res, rb)
+	}
+
+	err = json.Unmarshal(rb, &ar)
+	if err != nil {
+		return ar, err
+	}
+
+	return ar, nil
+}
+
+// Authorize establishes the session and returns the sid. It
+// ensures the authentication token (sessionToken) is valid
+// for the specific App (as identified by the clientId)
+//
+// This method reuires a configured clientID as it verifies
+// the pairing of the authenticated user and the application.
+// If Okta responds without establishing a session the error is
+// `ErrNoSessionCookie`.
+func (d *Dance) Authorize(ctx context.Context, sessionToken SessionToken) (SessionID, error) {
+	ar, err := d.AuthorizeToken(ctx, sessionToken)
+	if err != nil {
+		return "", err
+	}
+	if !ar.SessionID.Valid() {
+		return "", ErrNoSessionCookie
+	}
+	return ar.SessionID, nil
+}
+
+// ErrNoSessionCookie is returned when Okta responds successfully but
+// without the sid cookie, so no session was established
+var ErrNoSessionCookie = errors.New("no sid cookie in Okta's response")
+
+// WithPrompt sets the OIDC `prompt` parameter sent by `Authorize`. The
+// default, `none`, performs silent authorization and so requires an
+// existing session (ie the sessionToken from `Authenticate`); if Okta
+// would need to interact with the user it fails with
+// `ErrInteractionRequired`. Use `login` or `consent` to allow Okta to
+// interact with the user, or the empty string to omit the parameter and
+// let Okta decide.
+func WithPrompt(prompt string) Option {
 	return option(func(d *Dance) {
-		d.httpClient = hc
+		d.prompt = prompt
 	})
 }
 
-// WithLogger passes in a logging function, such as `log.Println`,
-// which will be used to log communication with Okta
-func WithLogger(log func(...interface{})) Option {
+// WithAuthorizationServer directs the OAuth operations (ie `Authorize`,
+// the token endpoint, and signing keys) to a custom authorization server,
+// `/oauth2/{id}/v1/...`, rather than the org authorization server at
+// `/oauth2/v1/...`. Use `default` for Okta's built in custom server.
+func WithAuthorizationServer(id string) Option {
 	return option(func(d *Dance) {
-		d.logger = log
+		d.authServer = id
 	})
 }
 
-// WithPrettyJSON forces pretty printed JSON on requests
-// and in logs
-func WithPrettyJSON() Option {
+// WithAuthorizeSuccess replaces how `Authorize` finds the sid in Okta's
+// response to the authorize request, for App configurations which signal
+// it differently, ie via a header. By default the sid is taken from the
+// `sid` cookie, or else a `sid` parameter on the redirect. The func is
+// given the final response, after any redirects followed via
+// `WithRedirectHandler`, and must not close its body. An error it returns
+// is returned from `Authorize`. The state and id_token on the redirect
+// are still checked as usual.
+func WithAuthorizeSuccess(success func(*http.Response) (SessionID, error)) Option {
 	return option(func(d *Dance) {
-		d.prettyJSON = true
+		d.authorizeSuccess = success
 	})
 }
 
-// Authenticate authenticates the user against Okta and returns a `sessionToken`.
-// The sessionToken needs to be given to the App which will then use `Authenticate`
-// to authenticate the user for that App. The sessionToken is only usable once.
-//
-// The `Multifactor` argument is used to complete multifactor authentication, if needed.
-// If you *know* you won't need m,ultifactor authentication, it may be nil.
-func (d *Dance) Authenticate(ctx context.Context, username, password string, mfa Multifactor) (SessionToken, error) {
-	body, err := json.Marshal(map[string]string{
-		"username": username,
-		"password": password,
+// WithLoginHint carries a known username, ie from an SSO initiated flow,
+// through the dance. It is sent as the OIDC `login_hint` parameter by
+// `Authorize`, and used as the username by `Authenticate` when none is
+// given.
+func WithLoginHint(hint string) Option {
+	return option(func(d *Dance) {
+		d.loginHint = hint
 	})
-	if err != nil {
-		return "", err
-	}
+}
 
-	req, err := http.NewRequest(
-		"POST",
-		fmt.Sprintf("https://%s/api/v1/authn", d.oktaDomain),
-		bytes.NewReader(body),
-	)
-	if err != nil {
-		return "", err
-	}
+// WithAuthorizeParam adds a query parameter to the authorize request made
+// by `Authorize`, ie `acr_values`, `max_age`, or `idp`. It may be given
+// more than once, including for the same key to send several values. A
+// parameter replaces any value the library would send for that key, ie
+// `scope`, except for those the dance depends on (`client_id`,
+// `redirect_uri`, `sessionToken`, `state` and `nonce`), which are ignored.
+func WithAuthorizeParam(key, value string) Option {
+	return option(func(d *Dance) {
+		params := url.Values{}
+		for k, vs := range d.authorizeParams {
+			params[k] = append([]string(nil), vs...)
+		}
+		params.Add(key, value)
+		d.authorizeParams = params
+	})
+}
 
-	req.Header["Content-type"] = []string{"application/json"}
-	req.Header["Accept"] = []string{"application/json"}
+// reservedAuthorizeParams may not be overridden via `WithAuthorizeParam`
+var reservedAuthorizeParams = map[string]bool{
+	"client_id":    true,
+	"redirect_uri": true,
+	"sessionToken": true,
+	"state":        true,
+	"nonce":        true,
+}
 
-	req = req.WithContext(ctx)
-	d.pre("Authenticate", req)
-	res, err := d.httpClient.Do(req)
-	if err != nil {
-		return "", err
+// oauthURL is the url of an endpoint on the configured
+// authorization server
+func (d *Dance) oauthURL(endpoint string) string {
+	if d.authServer == "" {
+		return fmt.Sprintf("https://%s/oauth2/v1/%s", d.oktaDomain, endpoint)
 	}
-	defer res.Body.Close()
-	d.post("Authenticate", res)
+	return fmt.Sprintf("https://%s/oauth2/%s/v1/%s", d.oktaDomain, url.PathEscape(d.authServer), endpoint)
+}
 
-	rb, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return "", err
-	}
+// AuthorizeResult is the outcome of authorizing a sessionToken
+// for an App
+type AuthorizeResult struct {
+	// SessionID is the sid established for the App
+	SessionID SessionID
 
-	ar := oktaUserAuthn{}
-	err = json.Unmarshal(rb, &ar)
-	if err != nil {
-		return "", err
-	}
+	// IDToken is the raw id_token JWT returned by Okta, if any
+	IDToken string
 
-	if ar.Status == "MFA_REQUIRED" {
-		var factor Factor
-		if len(ar.Embedded.Factors) == 1 {
-			factor = ar.Embedded.Factors[0].factor()
-		} else if len(ar.Embedded.Factors) == 0 {
-			return "", errors.New("MFA needed but no factoirs available")
-		} else {
-			factors := ar.Embedded.factors()
-			factor, err = mfa.Select(factors)
-			if err != nil {
-				return "", fmt.Errorf("error selecting MFA factor: %w", err)
-			}
-			if factor == nil {
-				return "", errors.New("no MFA was factor selected")
-			}
-			if factor == nil {
-				return "", errors.New("a factor was returned which was not passed in")
-			}
-		}
+	// Code is the authorization code returned by Okta, if any
+	Code string
 
-		if factor == nil {
-			return "", errors.New("MFA required but no factor selected")
-		}
+	// State is the random state sent with the request, which
+	// Okta's redirect was verified to have passed back
+	State string
 
-		return factor.perform(d, mfa, ar.StateToken)
-	}
+	// Nonce is the random nonce sent with the request, which
+	// the id_token was verified to contain
+	Nonce string
 
-	if ar.Status != "SUCCESS" {
-		return "", fmt.Errorf("Status: %s", ar.Status)
-	}
+	// Claims are the decoded claims from IDToken, or nil if
+	// no id_token was returned
+	Claims *IDTokenClaims
 
-	return SessionToken(ar.SessionToken), nil
+	// ExpiresAt is when the id_token, and so the authorization,
+	// expires. It is the zero time if no id_token was returned.
+	ExpiresAt time.Time
 }
 
-// Authorize establishes the session and returns the sid. It
-// ensures the authentication token (sessionToken) is valid
-// for the specific App (as identified by the clientId)
+// AuthorizeToken behaves as `Authorize`, but also returns the
+// id_token issued for the App along with its decoded claims. The
+// id_token signature is only verified if the Dance was configured
+// `WithVerifyIDToken`.
 //
-// This method reuires a configured clientID as it verifies
-// the pairing of the authenticated user and the application.
-func (d *Dance) Authorize(ctx context.Context, sessionToken SessionToken) (SessionID, error) {
-	u, err := url.Parse(fmt.Sprintf("https://%s/oauth2/v1/authorize", d.oktaDomain))
+// Okta may return its results via cookies or the query or fragment
+// of the redirect, depending on the App's configuration; all are
+// checked. An error passed back on the redirect is returned as an
+// `*OAuthError`.
+func (d *Dance) AuthorizeToken(ctx context.Context, sessionToken SessionToken) (*AuthorizeResult, error) {
+	if !sessionToken.Valid() {
+		return nil, ErrEmptySessionToken
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	au, err := d.endpoint(ctx, "authorize")
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	u, err := url.Parse(au)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := d.stateGenerator()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := d.stateGenerator()
+	if err != nil {
+		return nil, err
 	}
 
 	q := u.Query()
 	q.Add("client_id", d.clientID)
 	q.Add("redirect_uri", "https://epithet.io/okta-callback")
 	q.Add("sessionToken", string(sessionToken))
-	q.Add("prompt", "none")
+	if d.prompt != "" {
+		q.Add("prompt", d.prompt)
+	}
 	q.Add("response_type", "id_token")
 	q.Add("scope", "openid")
-	//q.Add("nonce", "waffles")
-	//q.Add("state", "fuzzy")
+	q.Add("nonce", nonce)
+	q.Add("state", state)
+	if d.loginHint != "" {
+		q.Add("login_hint", d.loginHint)
+	}
+	for k, vs := range d.authorizeParams {
+		if !reservedAuthorizeParams[k] {
+			q[k] = append([]string(nil), vs...)
+		}
+	}
 
 	u.RawQuery = q.Encode()
 
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req.Header["Accept"] = []string{"application/json"}
+	req.Header.Set("Accept", "application/json")
 
-	d.pre("Authorize", req)
-	res, err := d.httpClient.Do(req.WithContext(ctx))
+	rc := &redirectCookies{}
+	res, err := d.do("Authorize", req.WithContext(context.WithValue(ctx, redirectCookiesKey{}, rc)))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer res.Body.Close()
-	d.post("Authorize", res)
 	if res.StatusCode >= 400 {
 		buf, _ := ioutil.ReadAll(res.Body)
-		return "", errors.New(string(buf))
+		err = oktaError(res, buf)
+		var oe *OktaError
+		if errors.As(err, &oe) && oe.ErrorCode == invalidTokenCode {
+			return nil, fmt.Errorf("%w: %s", ErrSessionTokenConsumed, oe.ErrorSummary)
+		}
+		return nil, err
+	}
+
+	ar := &AuthorizeResult{State: state, Nonce: nonce}
+	if d.authorizeSuccess != nil {
+		ar.SessionID, err = d.authorizeSuccess(res)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, c := range append(rc.all(), res.Cookies()...) {
+			if c.Name == sessionCookieName {
+				ar.SessionID = SessionIDFromCookie(c)
+			}
+		}
+	}
+
+	params, err := redirectParams(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) > 0 && params.Get("state") != state {
+		return nil, ErrStateMismatch
+	}
+
+	if code := params.Get("error"); code != "" {
+		oe := &OAuthError{
+			Code:        code,
+			Description: params.Get("error_description"),
+		}
+		if isSessionTokenError(oe) {
+			return nil, fmt.Errorf("%w: %s", ErrSessionTokenConsumed, oe.Description)
+		}
+		return nil, oe
 	}
 
-	sid := ""
-	for _, c := range res.Cookies() {
-		if c.Name == "sid" {
-			sid = c.Value
+	if ar.SessionID == "" && d.authorizeSuccess == nil {
+		ar.SessionID = SessionID(params.Get(sessionCookieName))
+	}
+	ar.Code = params.Get("code")
+	ar.IDToken = params.Get("id_token")
+	if ar.IDToken != "" {
+		ar.Claims, err = d.idTokenClaims(ctx, ar.IDToken)
+		if err != nil {
+			return nil, err
 		}
+		if ar.Claims.Nonce != nonce {
+			return nil, fmt.Errorf("%w: nonce does not match request", ErrInvalidIDToken)
+		}
+		ar.ExpiresAt = ar.Claims.ExpiresAt()
+	}
+
+	return ar, nil
+}
+
+// ErrSessionTokenConsumed is returned from `Authorize` when Okta rejects
+// the sessionToken, typically because it has already been used or has
+// expired. A sessionToken is only usable once; the user must
+// `Authenticate` again to obtain a new one.
+var ErrSessionTokenConsumed = errors.New("sessionToken already used or expired")
+
+// invalidTokenCode is the Okta error code for an invalid token
+const invalidTokenCode = "E0000011"
+
+// isSessionTokenError reports whether an authorize redirect error
+// was caused by an unusable sessionToken
+func isSessionTokenError(oe *OAuthError) bool {
+	desc := strings.ToLower(oe.Description)
+	return strings.Contains(desc, "sessiontoken") || strings.Contains(desc, "session token")
+}
+
+// ErrStateMismatch is returned when the state Okta passes back on the
+// authorize redirect is not the state that was sent, which indicates
+// a forged or replayed response
+var ErrStateMismatch = errors.New("authorize state does not match request")
+
+// WithStateGenerator replaces the function used to generate the OAuth
+// state and nonce sent by `Authorize`, ie with a fixed generator for
+// deterministic tests. The values must be unguessable in production;
+// the default uses crypto/rand.
+func WithStateGenerator(generate func() (string, error)) Option {
+	return option(func(d *Dance) {
+		d.stateGenerator = generate
+	})
+}
+
+// randomString generates a random, url safe, string suitable for
+// use as an OAuth state or nonce
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// redirectParams extracts the parameters Okta passed back to the
+// redirect_uri, via either the query or fragment of the Location
+func redirectParams(res *http.Response) (url.Values, error) {
+	params := url.Values{}
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return params, nil
+	}
+
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect location: %w", err)
+	}
+
+	for k, vs := range u.Query() {
+		params[k] = append(params[k], vs...)
+	}
+
+	fragment, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect fragment: %w", err)
+	}
+	for k, vs := range fragment {
+		params[k] = append(params[k], vs...)
 	}
 
-	return SessionID(sid), nil
+	return params, nil
 }
 
 // Session retrieves the user session information from Okta for a
 // given SessionID (obtained via `Authenticate`). It can be run
 // from an untrusted client, if that client has the sessionId. The
 // sessionId is often referred to as the session cookie or sid.
+//
+// A session Okta does not know is reported as `ErrSessionNotFound`, and
+// one which is no longer active as `ErrSessionInactive`.
 func (d *Dance) Session(ctx context.Context, sessionID SessionID) (*Session, error) {
+	if !sessionID.Valid() {
+		return nil, ErrEmptySessionID
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
 	u := fmt.Sprintf("https://%s/api/v1/sessions/me", d.oktaDomain)
 	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.AddCookie(&http.Cookie{
-		Name:  "sid",
-		Value: string(sessionID),
-	})
+	req.AddCookie(sessionID.Cookie())
 
-	d.pre("Session", req)
-	res, err := d.httpClient.Do(req.WithContext(ctx))
+	res, err := d.do("Session", req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	d.post("Session", res)
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		err = oktaError(res, body)
+		oe := &OktaError{}
+		if res.StatusCode == http.StatusNotFound && errors.As(err, &oe) {
+			return nil, sessionNotFound{oe}
+		}
+		return nil, err
+	}
 
 	sess := &Session{}
 	err = json.Unmarshal(body, sess)
 	if err != nil {
 		return nil, err
 	}
+	if sess.Status == SessionInactive {
+		return nil, ErrSessionInactive
+	}
 
 	return sess, nil
 
@@ -278,23 +1462,25 @@ func (d *Dance) Session(ctx context.Context, sessionID SessionID) (*Session, err
 
 // RefreshSession extends the lifetime of the current session
 func (d *Dance) RefreshSession(ctx context.Context, sessionID SessionID) (*Session, error) {
+	if !sessionID.Valid() {
+		return nil, ErrEmptySessionID
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
 	u := fmt.Sprintf("https://%s/api/v1/sessions/me/lifecycle/refresh", d.oktaDomain)
 	req, err := http.NewRequest("POST", u, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.AddCookie(&http.Cookie{
-		Name:  "sid",
-		Value: string(sessionID),
-	})
+	req.AddCookie(sessionID.Cookie())
 
-	d.pre("RefreshSession", req)
-	res, err := d.httpClient.Do(req.WithContext(ctx))
+	res, err := d.do("RefreshSession", req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
-	d.post("RefreshToken", res)
 	if res.StatusCode >= 400 {
 		return nil, errors.New("session already expired")
 	}
@@ -303,52 +1489,105 @@ func (d *Dance) RefreshSession(ctx context.Context, sessionID SessionID) (*Sessi
 	if err != nil {
 		return nil, err
 	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
 
 	sess := &Session{}
 	err = json.Unmarshal(body, sess)
 	return sess, nil
 }
 
-// CloseSession closes the specified session
+// CloseSession closes the specified session. If the session does not
+// exist, ie it has already been closed or has expired, an `*OktaError`
+// with a StatusCode of 404 is returned, unless `WithIdempotentCloseSession`
+// is set.
 func (d *Dance) CloseSession(ctx context.Context, sessionID SessionID) error {
+	if !sessionID.Valid() {
+		return ErrEmptySessionID
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
 	u := fmt.Sprintf("https://%s/api/v1/sessions/me", d.oktaDomain)
 	req, err := http.NewRequest("DELETE", u, nil)
 	if err != nil {
 		return err
 	}
-	req.AddCookie(&http.Cookie{
-		Name:  "sid",
-		Value: string(sessionID),
-	})
+	req.AddCookie(sessionID.Cookie())
 
-	d.pre("CloseSession", req)
-	res, err := d.httpClient.Do(req.WithContext(ctx))
+	res, err := d.do("CloseSession", req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
-	d.post("CloseSession", res)
 
+	if res.StatusCode == http.StatusNotFound && d.closedSessionOK {
+		return nil
+	}
 	if res.StatusCode >= 300 {
 		body, _ := ioutil.ReadAll(res.Body)
-		return fmt.Errorf("Error closing session, status %d: %s", res.StatusCode, string(body))
+		return oktaError(res, body)
 	}
 
 	return nil
 }
 
+// WithIdempotentCloseSession makes `CloseSession` treat a session which
+// does not exist as already closed, returning nil, so that logout may be
+// safely retried.
+func WithIdempotentCloseSession() Option {
+	return option(func(d *Dance) {
+		d.closedSessionOK = true
+	})
+}
+
+// sessionAPI makes a request to the Okta API on behalf of the user
+// who owns the session, authenticated with the sid cookie
+func (d *Dance) sessionAPI(ctx context.Context, name, method, path string, sessionID SessionID) ([]byte, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("https://%s%s", d.oktaDomain, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(sessionID.Cookie())
+
+	res, err := d.do(name, req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, oktaError(res, body)
+	}
+
+	return body, nil
+}
+
 // Session is an OKTA Session, see
 // [Session Model](https://developer.okta.com/docs/reference/api/sessions/#session-model)
 type Session struct {
-	ID                       string    `json:"id"`
-	UserID                   string    `json:"userId"`
-	Login                    string    `json:"login"`
-	CreatedAt                time.Time `json:"createdAt"`
-	ExpiresAt                time.Time `json:"expiresAt"`
-	Status                   string    `json:"status"`
-	LastPasswordVerification time.Time `json:"lastPasswordVerification"`
-	LastFactorVerification   time.Time `json:"lastFactorVerification"`
-	Amr                      []string  `json:"amr"`
+	ID                       string        `json:"id"`
+	UserID                   string        `json:"userId"`
+	Login                    string        `json:"login"`
+	CreatedAt                time.Time     `json:"createdAt"`
+	ExpiresAt                time.Time     `json:"expiresAt"`
+	Status                   SessionStatus `json:"status"`
+	LastPasswordVerification time.Time     `json:"lastPasswordVerification"`
+	LastFactorVerification   time.Time     `json:"lastFactorVerification"`
+	Amr                      []string      `json:"amr"`
 	Idp                      struct {
 		ID   string `json:"id"`
 		Type string `json:"type"`
@@ -377,63 +1616,217 @@ type Session struct {
 	} `json:"_links"`
 }
 
-// pre is called before any http request in order to log the request
-// (and prettyprint the json body)
-func (d *Dance) pre(name string, req *http.Request) error {
-	if d.prettyJSON && req.Body != nil {
-		body, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			return err
+// do performs an http request against Okta, applying the headers
+// common to every request and logging the exchange
+func (d *Dance) do(name string, req *http.Request) (*http.Response, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.userAgent != "" {
+		req.Header.Set("User-Agent", d.userAgent)
+	}
+	for k, vs := range d.defaultHeaders {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = append([]string(nil), vs...)
+		}
+	}
+	if d.cookieJar != nil {
+		addJarCookies(req, d.cookieJar.Cookies(req.URL))
+	}
+
+	logName := name
+	if id := d.requestID(req.Context()); id != "" {
+		if req.Header.Get(d.requestIDHeader) == "" {
+			req.Header.Set(d.requestIDHeader, id)
+		}
+		logName = fmt.Sprintf("%s [%s]", name, id)
+	}
+
+	d.pre(logName, req)
+	res, err := d.send(name, req)
+	if err != nil {
+		return nil, err
+	}
+	if d.maxResponseBytes > 0 {
+		res.Body = &limitedBody{
+			r:   io.LimitReader(res.Body, d.maxResponseBytes+1),
+			rc:  res.Body,
+			max: d.maxResponseBytes,
 		}
-		s := map[string]interface{}{}
-		err = json.Unmarshal(body, &s)
-		body, err = json.MarshalIndent(s, "", "  ")
+	}
+	d.post(logName, res)
+
+	if d.cookieJar != nil {
+		d.cookieJar.SetCookies(req.URL, res.Cookies())
+	}
+
+	if d.interceptor != nil {
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
 		if err != nil {
-			return err
+			return nil, err
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		cp := *res
+		cp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		d.interceptor(name, &cp)
+	}
+
+	return res, nil
+}
+
+// DefaultMaxResponseBytes is the largest response body read from Okta
+// unless overridden via `WithMaxResponseBytes`
+const DefaultMaxResponseBytes = 1 << 20
+
+// ErrResponseTooLarge is returned when a response body is larger than
+// allowed by `WithMaxResponseBytes`
+var ErrResponseTooLarge = errors.New("response too large")
+
+// WithMaxResponseBytes limits how much of a response body is read, so a
+// misbehaving proxy returning a huge body cannot exhaust memory. Reading
+// a larger body fails with `ErrResponseTooLarge`. The default is
+// `DefaultMaxResponseBytes`, 0 removes the limit.
+func WithMaxResponseBytes(max int64) Option {
+	return option(func(d *Dance) {
+		d.maxResponseBytes = max
+	})
+}
+
+// limitedBody fails reads once more than max bytes have been read
+type limitedBody struct {
+	r    io.Reader
+	rc   io.ReadCloser
+	max  int64
+	read int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.max {
+		return n, fmt.Errorf("%w: over %d bytes", ErrResponseTooLarge, b.max)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.rc.Close()
+}
+
+// WithRequestIDHeader propagates a correlation ID from the context to
+// Okta, tying Okta's logs to your own request traces. The value stored in
+// the context under key, which must be a string or `fmt.Stringer`, is sent
+// as the named header, ie `X-Request-Id`, and included in log lines.
+func WithRequestIDHeader(name string, key interface{}) Option {
+	return option(func(d *Dance) {
+		d.requestIDHeader = http.CanonicalHeaderKey(name)
+		d.requestIDKey = key
+	})
+}
+
+// requestID gives the correlation ID in the context, if configured
+func (d *Dance) requestID(ctx context.Context) string {
+	if d.requestIDHeader == "" || d.requestIDKey == nil {
+		return ""
+	}
+	switch v := ctx.Value(d.requestIDKey).(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return ""
+}
+
+// addJarCookies adds the cookies from a jar to the request, unless the
+// request already carries a cookie of the same name
+func addJarCookies(req *http.Request, cookies []*http.Cookie) {
+	for _, c := range cookies {
+		if _, err := req.Cookie(c.Name); err == http.ErrNoCookie {
+			req.AddCookie(c)
 		}
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
-		req.ContentLength = int64(len(body))
 	}
+}
 
+// pre is called before any http request in order to log the request
+func (d *Dance) pre(name string, req *http.Request) error {
 	if d.logger == nil {
 		return nil
 	}
 
-	dmp, err := httputil.DumpRequest(req, true)
+	// dump a copy with redacted headers and secrets, leaving the original
+	// body intact
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return err
+	}
+	r := *req
+	r.Header = redactHeaders(req.Header)
+	dmp, err := httputil.DumpRequest(&r, false)
 	if err != nil {
 		return err
 	}
 
-	d.logger(name, string(dmp))
+	d.logger.Debug(name, sanitizeBody(append(dmp, d.logBody(body)...)))
 	return nil
 }
 
 // post is called after any http request in order to log the response
 func (d *Dance) post(name string, res *http.Response) error {
-	if d.prettyJSON && res.Body != nil {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
-		}
-		s := map[string]interface{}{}
-		err = json.Unmarshal(body, &s)
-		body, err = json.MarshalIndent(s, "", "  ")
-		if err != nil {
-			return err
-		}
-		res.Body = ioutil.NopCloser(bytes.NewBuffer(body))
-		res.ContentLength = int64(len(body))
-	}
-
 	if d.logger == nil {
 		return nil
 	}
 
-	dmp, err := httputil.DumpResponse(res, true)
+	body, err := readBody(&res.Body)
+	if err != nil {
+		return err
+	}
+	r := *res
+	r.Header = redactHeaders(res.Header)
+	dmp, err := httputil.DumpResponse(&r, false)
 	if err != nil {
 		return err
 	}
 
-	d.logger(name, string(dmp))
+	d.logger.Debug(name, sanitizeBody(append(dmp, d.logBody(body)...)))
 	return nil
 }
+
+// readBody reads the body, replacing it with an unread copy
+func readBody(rc *io.ReadCloser) ([]byte, error) {
+	if *rc == nil || *rc == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(*rc)
+	(*rc).Close()
+	if err != nil {
+		// hand the error on to whoever reads the body next
+		*rc = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		return body, err
+	}
+	*rc = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// logBody formats a body for logging, pretty printing it when asked
+// to and it is JSON
+func (d *Dance) logBody(body []byte) string {
+	if d.prettyJSON {
+		buf := &bytes.Buffer{}
+		if json.Indent(buf, body, "", "  ") == nil {
+			return buf.String()
+		}
+	}
+	return string(body)
+}
@@ -2,10 +2,20 @@ package oktadance_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/brianm/oktadance"
 	"github.com/stretchr/testify/assert"
@@ -175,3 +185,1073 @@ func (t testMFA) Select(factors []oktadance.Factor) (oktadance.Factor, error) {
 func (t testMFA) ReadCode(f oktadance.Factor) (string, error) {
 	return "", nil
 }
+
+func TestDance_UserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []oktadance.Option
+		want    string
+	}{
+		{"default", nil, oktadance.DefaultUserAgent},
+		{"custom", []oktadance.Option{oktadance.WithUserAgent("waffles/1.0")}, "waffles/1.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+				got = r.UserAgent()
+				writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess"})
+			})
+			d, srv := mockOkta(t, mux, tc.options...)
+			defer srv.Close()
+
+			_, err := d.Session(context.Background(), "sid")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDance_Concurrent(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess", "login": "user"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithPrettyJSON())
+	defer srv.Close()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := d.Authenticate(ctx, "user", "pass", nil)
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := d.Session(ctx, "sid")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestDance_DefaultTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithDefaultTimeout(20*time.Millisecond))
+	defer srv.Close()
+
+	_, err := d.Session(context.Background(), "sid")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = d.Session(ctx, "sid")
+	assert.NoError(t, err)
+}
+
+func TestContextWithOptions(t *testing.T) {
+	var agents []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithUserAgent("default"))
+	defer srv.Close()
+
+	ctx := oktadance.ContextWithOptions(context.Background(), oktadance.WithUserAgent("first"))
+	ctx = oktadance.ContextWithOptions(ctx, oktadance.WithUserAgent("scoped"))
+	_, err := d.Session(ctx, "sid")
+	require.NoError(t, err)
+
+	_, err = d.Authenticate(context.Background(), "user", "pass", nil, oktadance.WithUserAgent("call"))
+	require.NoError(t, err)
+
+	_, err = d.Session(context.Background(), "sid")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"scoped", "call", "default"}, agents)
+}
+
+func TestContextWithOptions_NotPerCall(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess"})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	options := []oktadance.Option{
+		oktadance.WithHTTPClient(srv.Client()),
+		oktadance.WithInsecureSkipVerify(),
+		oktadance.WithRedirectHandler(func(*http.Request, []*http.Request) error { return nil }),
+		oktadance.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper { return next }),
+		oktadance.WithMaxConcurrentRequests(1),
+		oktadance.WithDialTimeout(time.Second),
+		oktadance.WithTLSHandshakeTimeout(time.Second),
+		oktadance.WithResponseHeaderTimeout(time.Second),
+		oktadance.WithAuthnDedup(),
+		oktadance.WithBufferedFlowLogs(),
+	}
+	for i, o := range options {
+		ctx := oktadance.ContextWithOptions(context.Background(), oktadance.WithUserAgent("ok"), o)
+		_, err := d.Session(ctx, "sid")
+		assert.Equal(t, oktadance.ErrNotPerCallOption, err, "option %d", i)
+		_, err = d.Authenticate(ctx, "user", "pass", nil)
+		assert.Equal(t, oktadance.ErrNotPerCallOption, err, "option %d", i)
+	}
+
+	// nor may they be given for a single Authenticate
+	for i, o := range options {
+		_, err := d.Authenticate(context.Background(), "user", "pass", nil, oktadance.WithUserAgent("ok"), o)
+		assert.Equal(t, oktadance.ErrNotPerCallOption, err, "option %d", i)
+	}
+}
+
+func TestDance_CookieJar(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var sessionCookies []*http.Cookie
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "DT", Value: "device", Path: "/"})
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		sessionCookies = r.Cookies()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess"})
+	})
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(err)
+	d, srv := mockOkta(t, mux, oktadance.WithCookieJar(jar))
+	defer srv.Close()
+
+	ctx := context.Background()
+	_, err = d.Authenticate(ctx, "user", "pass", nil)
+	require.NoError(err)
+
+	_, err = d.Session(ctx, "sid")
+	require.NoError(err)
+
+	names := map[string]string{}
+	for _, c := range sessionCookies {
+		names[c.Name] = c.Value
+	}
+	assert.Equal(map[string]string{"sid": "sid", "DT": "device"}, names)
+}
+
+func TestDance_ResponseInterceptor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Remaining", "42")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess", "login": "user"})
+	})
+
+	var op, remaining, body string
+	d, srv := mockOkta(t, mux, oktadance.WithResponseInterceptor(func(name string, res *http.Response) {
+		op = name
+		remaining = res.Header.Get("X-Rate-Limit-Remaining")
+		buf, _ := ioutil.ReadAll(res.Body)
+		body = string(buf)
+	}))
+	defer srv.Close()
+
+	sess, err := d.Session(context.Background(), "sid")
+	require.NoError(t, err)
+	assert.Equal(t, "user", sess.Login)
+	assert.Equal(t, "Session", op)
+	assert.Equal(t, "42", remaining)
+	assert.Contains(t, body, `"login":"user"`)
+}
+
+func TestDance_DefaultHeaders(t *testing.T) {
+	var got http.Header
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithDefaultHeaders(http.Header{
+		"x-gateway-key": {"secret"},
+		"Accept":        {"text/html"},
+	}))
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", got.Get("X-Gateway-Key"))
+	assert.Equal(t, []string{"application/json"}, got["Accept"])
+}
+
+func TestDance_Authenticate_ContentType(t *testing.T) {
+	var got http.Header
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithDefaultHeaders(http.Header{
+		"Content-Type": {"text/plain"},
+	}))
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"application/json"}, got["Content-Type"])
+}
+
+func TestDance_AuthenticateWith(t *testing.T) {
+	var got map[string]interface{}
+	var userAgent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		json.NewDecoder(r.Body).Decode(&got)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	ar, err := d.AuthenticateWith(context.Background(), oktadance.AuthnRequest{
+		Username:    "user",
+		Password:    "pass",
+		DeviceToken: "device",
+		Options:     []oktadance.Option{oktadance.WithUserAgent("one-off")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), ar.SessionToken)
+	assert.Equal(t, "user", got["username"])
+	assert.Equal(t, map[string]interface{}{"deviceToken": "device"}, got["context"])
+	assert.Equal(t, "one-off", userAgent)
+
+	// the options only apply to that one request
+	_, err = d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.DefaultUserAgent, userAgent)
+
+	for _, o := range []oktadance.Option{oktadance.WithAuthnDedup(), oktadance.WithMaxConcurrentRequests(1)} {
+		userAgent = ""
+		_, err = d.AuthenticateWith(context.Background(), oktadance.AuthnRequest{
+			Username: "user",
+			Password: "pass",
+			Options:  []oktadance.Option{o},
+		})
+		assert.Equal(t, oktadance.ErrNotPerCallOption, err)
+		assert.Empty(t, userAgent, "no request should be made")
+	}
+}
+
+func TestDance_AuthenticateWith_PasswordWarn(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var got map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "PASSWORD_WARN",
+			"_embedded": map[string]interface{}{
+				"policy": map[string]interface{}{
+					"expiration": map[string]interface{}{"passwordExpireDays": 4},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/lifecycle/skip", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal("state", body["stateToken"])
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	ar, err := d.AuthenticateWith(context.Background(), oktadance.AuthnRequest{
+		Username:                  "user",
+		Password:                  "pass",
+		Audience:                  "aud",
+		WarnBeforePasswordExpired: true,
+	})
+	require.NoError(err)
+	assert.Equal(oktadance.SessionToken("token"), ar.SessionToken)
+	assert.True(ar.PasswordExpiresSoon)
+	assert.WithinDuration(time.Now().AddDate(0, 0, 4), ar.PasswordExpiresAt, time.Minute)
+
+	assert.Equal("aud", got["audience"])
+	assert.Equal(map[string]interface{}{
+		"multiOptionalFactorEnroll": false,
+		"warnBeforePasswordExpired": true,
+	}, got["options"])
+}
+
+func TestDance_With(t *testing.T) {
+	var userAgents []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.UserAgent())
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sess"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithUserAgent("base"))
+	defer srv.Close()
+
+	clone := d.With(oktadance.WithUserAgent("clone"))
+
+	ctx := context.Background()
+	_, err := clone.Session(ctx, "sid")
+	require.NoError(t, err)
+	_, err = d.Session(ctx, "sid")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"clone", "base"}, userAgents)
+}
+
+// closeRecorder records whether the client's idle connections were closed
+type closeRecorder struct {
+	http.RoundTripper
+	closed bool
+}
+
+func (c *closeRecorder) CloseIdleConnections() { c.closed = true }
+
+func TestDance_With_ClientOptions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	mux.HandleFunc("/moved/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/api/v1/sessions/me", http.StatusFound)
+	})
+	mux.HandleFunc("/slow/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+	ctx := context.Background()
+
+	base := oktadance.New(host)
+	insecure := base.With(oktadance.WithInsecureSkipVerify())
+	defer insecure.Close()
+	_, err := insecure.Session(ctx, "sid")
+	require.NoError(t, err)
+	_, err = base.Session(ctx, "sid")
+	assert.Error(t, err, "the original should still verify certificates")
+
+	wrapped := 0
+	_, err = insecure.With(oktadance.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			wrapped++
+			return next.RoundTrip(req)
+		})
+	})).Session(ctx, "sid")
+	require.NoError(t, err)
+	assert.Equal(t, 1, wrapped)
+
+	moved := insecure.With(oktadance.WithDomain(host + "/moved"))
+	_, err = moved.Session(ctx, "sid")
+	assert.Error(t, err, "redirects are not followed by default")
+	_, err = moved.With(oktadance.WithRedirectHandler(func(*http.Request, []*http.Request) error { return nil })).Session(ctx, "sid")
+	require.NoError(t, err)
+
+	slow := insecure.With(oktadance.WithDomain(host+"/slow"), oktadance.WithResponseHeaderTimeout(20*time.Millisecond))
+	_, err = slow.Session(ctx, "sid")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+
+	rec := &closeRecorder{RoundTripper: srv.Client().Transport}
+	custom := insecure.With(oktadance.WithHTTPClient(&http.Client{Transport: rec}))
+	_, err = custom.Session(ctx, "sid")
+	assert.True(t, errors.Is(err, oktadance.ErrHTTPClientConflict), "unexpected error: %v", err)
+	require.NoError(t, custom.Close())
+	assert.False(t, rec.closed, "the given client belongs to the caller")
+
+	custom = base.With(oktadance.WithHTTPClient(&http.Client{Transport: rec}))
+	_, err = custom.Session(ctx, "sid")
+	require.NoError(t, err)
+	require.NoError(t, custom.Close())
+	assert.False(t, rec.closed, "the given client belongs to the caller")
+}
+
+func TestDance_LoggerRedactsCredentials(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/me", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "fresh-secret", Path: "/"})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "00u1"})
+	})
+
+	var logs []string
+	d, srv := mockOkta(t, mux, oktadance.WithLogger(func(args ...interface{}) {
+		logs = append(logs, fmt.Sprint(args...))
+	}))
+	defer srv.Close()
+
+	user, err := d.Me(context.Background(), "sid-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "00u1", user.ID)
+
+	all := strings.Join(logs, "\n")
+	assert.NotContains(t, all, "sid-secret")
+	assert.NotContains(t, all, "fresh-secret")
+	assert.Contains(t, all, "sid=REDACTED")
+}
+
+func TestDance_LoggerRedactsSecretsInBodies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state-secret",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token-secret",
+		})
+	})
+	mux.HandleFunc("/oauth2/v1/authorize", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123"})
+		w.Header().Set("Location", r.URL.Query().Get("redirect_uri")+"?state="+url.QueryEscape(r.URL.Query().Get("state")))
+		w.WriteHeader(http.StatusFound)
+	})
+
+	var logs []string
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"), oktadance.WithLogger(func(args ...interface{}) {
+		logs = append(logs, fmt.Sprint(args...))
+	}))
+	defer srv.Close()
+
+	mfa := oktadance.MultifactorFunc{
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "passcode-secret", nil },
+	}
+	token, err := d.Authenticate(context.Background(), "user", "password-secret", mfa)
+	require.NoError(t, err)
+	_, err = d.Authorize(context.Background(), token)
+	require.NoError(t, err)
+
+	all := strings.Join(logs, "\n")
+	for _, secret := range []string{"password-secret", "state-secret", "passcode-secret", "token-secret"} {
+		assert.NotContains(t, all, secret)
+	}
+	assert.Contains(t, all, `"password":"REDACTED"`)
+	assert.Contains(t, all, "sessionToken=REDACTED")
+}
+
+func TestDance_LoggerRedactsSessionCookieRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/sessionCookieRedirect", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123", Path: "/"})
+		w.Header().Set("Location", r.URL.Query().Get("redirectUrl"))
+		w.WriteHeader(http.StatusFound)
+	})
+
+	var logs []string
+	d, srv := mockOkta(t, mux, oktadance.WithLogger(func(args ...interface{}) {
+		logs = append(logs, fmt.Sprint(args...))
+	}))
+	defer srv.Close()
+
+	_, err := d.EstablishSession(context.Background(), "token-secret", "https://app.example.com/")
+	require.NoError(t, err)
+
+	all := strings.Join(logs, "\n")
+	assert.NotContains(t, all, "token-secret")
+	assert.Contains(t, all, "/login/sessionCookieRedirect?")
+	assert.Contains(t, all, "token=REDACTED")
+}
+
+func TestDance_BufferedFlowLogs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"errorCode":    "E0000068",
+			"errorSummary": "Invalid Passcode/Answer",
+		})
+	})
+
+	var names []string
+	var logs []string
+	d, srv := mockOkta(t, mux, oktadance.WithBufferedFlowLogs(), oktadance.WithMaxCodeAttempts(1), oktadance.WithLogger(func(args ...interface{}) {
+		names = append(names, fmt.Sprint(args[0]))
+		logs = append(logs, fmt.Sprint(args...))
+	}))
+	defer srv.Close()
+
+	mfa := oktadance.MultifactorFunc{
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "000000", nil },
+	}
+	_, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.Error(t, err)
+
+	require.Len(t, logs, 1)
+	assert.True(t, strings.HasPrefix(names[0], "Authenticate flow "), "unexpected name: %s", names[0])
+	assert.Contains(t, logs[0], "POST /api/v1/authn ")
+	assert.Contains(t, logs[0], "POST /api/v1/authn/factors/totp1/verify ")
+	assert.Contains(t, logs[0], "E0000068")
+
+	_, err = d.Session(context.Background(), "sid")
+	require.Error(t, err)
+	assert.Len(t, logs, 3, "calls outside a flow are logged as they happen")
+}
+
+func TestSessionID_JSON(t *testing.T) {
+	type state struct {
+		SID   oktadance.SessionID    `json:"sid"`
+		Token oktadance.SessionToken `json:"token,omitempty"`
+	}
+
+	buf, err := json.Marshal(state{SID: "sid123"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"sid": "sid123"}`, string(buf))
+
+	st := state{}
+	require.NoError(t, json.Unmarshal(buf, &st))
+	assert.Equal(t, oktadance.SessionID("sid123"), st.SID)
+	assert.True(t, st.SID.Valid())
+	assert.False(t, st.Token.Valid())
+
+	_, err = json.Marshal(state{})
+	assert.True(t, errors.Is(err, oktadance.ErrEmptySessionID), "unexpected error: %v", err)
+
+	err = json.Unmarshal([]byte(`{"sid": ""}`), &st)
+	assert.True(t, errors.Is(err, oktadance.ErrEmptySessionID), "unexpected error: %v", err)
+}
+
+func TestSessionID_Cookie(t *testing.T) {
+	c := oktadance.SessionID("sid123").Cookie()
+	assert.Equal(t, "sid", c.Name)
+	assert.Equal(t, "sid123", c.Value)
+	assert.Equal(t, oktadance.SessionID("sid123"), oktadance.SessionIDFromCookie(c))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(c)
+	got, err := req.Cookie("sid")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionID("sid123"), oktadance.SessionIDFromCookie(got))
+
+	assert.Equal(t, oktadance.SessionID(""), oktadance.SessionIDFromCookie(nil))
+	assert.Equal(t, oktadance.SessionID(""), oktadance.SessionIDFromCookie(&http.Cookie{Name: "JSESSIONID", Value: "sid123"}))
+}
+
+func TestDance_Session_EmptySessionID(t *testing.T) {
+	d := oktadance.New("example.okta.com")
+	_, err := d.Session(context.Background(), "")
+	assert.Equal(t, oktadance.ErrEmptySessionID, err)
+}
+
+func TestDance_Session_NotFoundOrInactive(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("sid")
+		require.NoError(t, err)
+		if c.Value == "inactive" {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": "inactive", "status": "INACTIVE"})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"errorCode":    "E0000007",
+			"errorSummary": "Not found: Resource not found: me (Session)",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Session(context.Background(), "gone")
+	assert.True(t, errors.Is(err, oktadance.ErrSessionNotFound), "unexpected error: %v", err)
+	oe := &oktadance.OktaError{}
+	require.True(t, errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal(t, "E0000007", oe.ErrorCode)
+
+	_, err = d.Session(context.Background(), "inactive")
+	assert.Equal(t, oktadance.ErrSessionInactive, err)
+}
+
+func TestDance_InsecureSkipVerify(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	_, err := oktadance.New(host).Session(context.Background(), "sid")
+	assert.Error(t, err, "self-signed certificate should be rejected by default")
+
+	s, err := oktadance.New(host, oktadance.WithInsecureSkipVerify()).Session(context.Background(), "sid")
+	require.NoError(t, err)
+	assert.Equal(t, "sid", s.ID)
+
+	d := oktadance.New(host, oktadance.WithInsecureSkipVerify(), oktadance.WithHTTPClient(srv.Client()))
+	_, err = d.Session(context.Background(), "sid")
+	assert.True(t, errors.Is(err, oktadance.ErrHTTPClientConflict), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "WithInsecureSkipVerify")
+
+	// only the first conflict found is reported
+	d = d.With(oktadance.WithResponseHeaderTimeout(time.Second))
+	_, err = d.Session(context.Background(), "sid")
+	assert.EqualError(t, err, "WithInsecureSkipVerify: option cannot be used with WithHTTPClient")
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithTransportWrapper(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "wrapped", r.Header.Get("X-Middleware"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	hc := srv.Client()
+	base := hc.Transport
+	var paths []string
+	d := oktadance.New(host, oktadance.WithHTTPClient(hc), oktadance.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			paths = append(paths, req.URL.Path)
+			req.Header.Set("X-Middleware", "wrapped")
+			return next.RoundTrip(req)
+		})
+	}))
+
+	_, err := d.Session(context.Background(), "sid")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/api/v1/sessions/me"}, paths)
+	assert.Equal(t, base, hc.Transport, "the given client should be left untouched")
+}
+
+func TestDance_WithResponseHeaderTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	d := oktadance.New(host, oktadance.WithInsecureSkipVerify(), oktadance.WithResponseHeaderTimeout(20*time.Millisecond))
+	defer d.Close()
+	_, err := d.Session(context.Background(), "sid")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+
+	patient := oktadance.New(host, oktadance.WithInsecureSkipVerify())
+	defer patient.Close()
+	_, err = patient.Session(context.Background(), "sid")
+	require.NoError(t, err)
+
+	impatient := patient.With(oktadance.WithResponseHeaderTimeout(20 * time.Millisecond))
+	defer impatient.Close()
+	_, err = impatient.Session(context.Background(), "sid")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+
+	ctx := oktadance.ContextWithOptions(context.Background(), oktadance.WithResponseHeaderTimeout(20*time.Millisecond))
+	_, err = patient.Session(ctx, "sid")
+	assert.Equal(t, oktadance.ErrNotPerCallOption, err)
+
+	d = oktadance.New(host, oktadance.WithDialTimeout(time.Second), oktadance.WithHTTPClient(srv.Client()))
+	_, err = d.Session(context.Background(), "sid")
+	assert.True(t, errors.Is(err, oktadance.ErrHTTPClientConflict), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "WithDialTimeout")
+}
+
+func TestDance_CloseSession_NotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "DELETE", r.Method)
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"errorCode":    "E0000007",
+			"errorSummary": "Not found: Resource not found: me (Session)",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	err := d.CloseSession(context.Background(), "sid")
+	oe := &oktadance.OktaError{}
+	require.True(t, errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal(t, http.StatusNotFound, oe.StatusCode)
+
+	err = d.With(oktadance.WithIdempotentCloseSession()).CloseSession(context.Background(), "sid")
+	assert.NoError(t, err)
+}
+
+type requestIDKey struct{}
+
+func TestDance_RequestIDHeader(t *testing.T) {
+	var got string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/me", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-Id")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "00u1"})
+	})
+
+	var logs []string
+	d, srv := mockOkta(t, mux,
+		oktadance.WithRequestIDHeader("X-Request-Id", requestIDKey{}),
+		oktadance.WithLogger(func(args ...interface{}) {
+			logs = append(logs, fmt.Sprint(args...))
+		}),
+	)
+	defer srv.Close()
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-123")
+	_, err := d.Me(ctx, "sid")
+	require.NoError(t, err)
+	assert.Equal(t, "req-123", got)
+	require.NotEmpty(t, logs)
+	assert.Contains(t, logs[0], "[req-123]")
+
+	_, err = d.Me(context.Background(), "sid")
+	require.NoError(t, err)
+	assert.Equal(t, "", got)
+}
+
+func TestDance_PrettyJSONLogsOnly(t *testing.T) {
+	var sent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		sent = string(buf)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+
+	var logs []string
+	d, srv := mockOkta(t, mux,
+		oktadance.WithPrettyJSON(),
+		oktadance.WithLogger(func(args ...interface{}) {
+			logs = append(logs, fmt.Sprint(args...))
+		}),
+	)
+	defer srv.Close()
+
+	token, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+
+	assert.NotContains(t, sent, "\n", "request body should not be rewritten")
+	require.Len(t, logs, 2)
+	assert.Contains(t, logs[0], "\n  \"username\": \"user\"")
+	assert.Contains(t, logs[1], "\n  \"sessionToken\": \"REDACTED\"")
+}
+
+func TestDance_DeviceFingerprint(t *testing.T) {
+	var got string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Device-Fingerprint")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithDeviceFingerprint("fp123"))
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "fp123", got)
+}
+
+func TestDance_ForwardedFor(t *testing.T) {
+	var got []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Forwarded-For"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+
+	_, err = d.With(oktadance.WithForwardedFor("203.0.113.7")).Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+
+	ctx := oktadance.ContextWithOptions(context.Background(), oktadance.WithForwardedFor("198.51.100.2"))
+	_, err = d.Authenticate(ctx, "user", "pass", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"", "203.0.113.7", "198.51.100.2"}, got)
+}
+
+func TestDance_UnexpectedContentType(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html><body>Okta is down for maintenance</body></html>")
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	assert.True(t, errors.Is(err, oktadance.ErrUnexpectedContentType), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "down for maintenance")
+	assert.Contains(t, err.Error(), "503")
+}
+
+func TestDance_Close(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	d := oktadance.New(strings.TrimPrefix(srv.URL, "https://"), oktadance.WithInsecureSkipVerify())
+	_, err := d.Session(context.Background(), "sid")
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	// still usable after closing idle connections
+	_, err = d.Session(context.Background(), "sid")
+	require.NoError(t, err)
+}
+
+func TestDance_MaxResponseBytes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     "sid",
+			"status": "ACTIVE",
+			"login":  strings.Repeat("x", 2048),
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithMaxResponseBytes(1024))
+	defer srv.Close()
+
+	_, err := d.Session(context.Background(), "sid")
+	assert.True(t, errors.Is(err, oktadance.ErrResponseTooLarge), "unexpected error: %v", err)
+
+	// also when the body has been read for logging first
+	_, err = d.With(oktadance.WithLogger(func(...interface{}) {})).Session(context.Background(), "sid")
+	assert.True(t, errors.Is(err, oktadance.ErrResponseTooLarge), "unexpected error: %v", err)
+
+	s, err := d.With(oktadance.WithMaxResponseBytes(0)).Session(context.Background(), "sid")
+	require.NoError(t, err)
+	assert.Equal(t, "sid", s.ID)
+}
+
+func TestDance_AuthnDedup(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAuthnDedup())
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	tokens := make([]oktadance.SessionToken, 3)
+	errs := make([]error, 3)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = d.Authenticate(context.Background(), "user", "pass", nil)
+		}(i)
+	}
+
+	// give the calls time to join the first before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range tokens {
+		require.NoError(t, errs[i])
+		assert.Equal(t, oktadance.SessionToken("token"), tokens[i])
+	}
+	assert.Equal(t, 1, calls)
+
+	// a different password is not deduplicated with the first
+	_, err := d.Authenticate(context.Background(), "user", "other", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// nor are calls with different settings, or with options
+	release = make(chan struct{})
+	calls = 0
+	requests := []oktadance.AuthnRequest{
+		{Username: "user", Password: "pass"},
+		{Username: "user", Password: "pass", WarnBeforePasswordExpired: true},
+		{Username: "user", Password: "pass", MultiOptionalFactorEnroll: true},
+		{Username: "user", Password: "pass", Options: []oktadance.Option{oktadance.WithForwardedFor("203.0.113.7")}},
+	}
+	contexts := []context.Context{
+		context.Background(),
+		context.Background(),
+		context.Background(),
+		context.Background(),
+		oktadance.ContextWithOptions(context.Background(), oktadance.WithForwardedFor("198.51.100.2")),
+	}
+	requests = append(requests, requests[0])
+	errs = make([]error, len(requests))
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = d.AuthenticateWith(contexts[i], requests[i])
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range requests {
+		require.NoError(t, errs[i])
+	}
+	mu.Lock()
+	assert.Equal(t, len(requests), calls)
+	mu.Unlock()
+
+	// nor are calls from copies which send the request differently, or
+	// to another org
+	release = make(chan struct{})
+	calls = 0
+	dances := []*oktadance.Dance{
+		d,
+		d.With(oktadance.WithClientID("other")),
+		d.With(oktadance.WithForwardedFor("203.0.113.7")),
+		d.With(oktadance.WithDeviceFingerprint("device")),
+		d.With(oktadance.WithDomain("127.0.0.1:1")),
+	}
+	errs = make([]error, len(dances))
+	for i := range dances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = dances[i].Authenticate(context.Background(), "user", "pass", nil)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range dances[:4] {
+		require.NoError(t, errs[i])
+	}
+	assert.Error(t, errs[4], "the other org's call should not get this org's result")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 4, calls)
+}
+
+func TestDance_ErrorsAreSanitized(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+			"stateToken":   "state-secret",
+			"sessionToken": "token-secret",
+		})
+	})
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, `<a href="/login?sessionToken=token-secret&x=1">retry</a>`)
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+	assert.Contains(t, err.Error(), `"sessionToken":"REDACTED"`)
+
+	_, err = d.Session(context.Background(), "sid")
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+	assert.Contains(t, err.Error(), "sessionToken=REDACTED&x=1")
+}
@@ -0,0 +1,32 @@
+package oktadance
+
+// LeveledLogger receives log lines at different levels. Dumps of the
+// requests to and responses from Okta, which are verbose and may carry
+// secrets despite redaction, are logged at Debug; retries and rate
+// limiting at Warn; and failures which are otherwise swallowed, such as
+// cancelling an abandoned authn transaction, at Error. Each line is given
+// as for `log.Println`.
+type LeveledLogger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// WithLeveledLogger logs via a `LeveledLogger`, so that, ie, production
+// deployments can keep warnings while discarding request dumps. It
+// replaces any logger given via `WithLogger`.
+func WithLeveledLogger(logger LeveledLogger) Option {
+	return option(func(d *Dance) {
+		d.logger = logger
+	})
+}
+
+// funcLogger logs every level via a single function, as given to
+// `WithLogger`
+type funcLogger func(...interface{})
+
+func (f funcLogger) Debug(args ...interface{}) { f(args...) }
+func (f funcLogger) Info(args ...interface{})  { f(args...) }
+func (f funcLogger) Warn(args ...interface{})  { f(args...) }
+func (f funcLogger) Error(args ...interface{}) { f(args...) }
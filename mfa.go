@@ -1,22 +1,59 @@
 package oktadance
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"math/rand"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+var (
+	// ErrPushRejected is returned when the user denies a push notification
+	ErrPushRejected = errors.New("push notification was rejected")
+
+	// ErrPushTimeout is returned when a push notification expires
+	// before the user responds to it
+	ErrPushTimeout = errors.New("push notification timed out")
+
+	// ErrTooManyAttempts is returned when the user enters a wrong
+	// code more times than allowed by `WithMaxCodeAttempts`
+	ErrTooManyAttempts = errors.New("too many incorrect MFA codes")
+
+	// ErrNoFactorsEnrolled is returned when MFA is required but the user
+	// has not enrolled any factors. The user needs to enroll a factor,
+	// ie by signing in to Okta in a browser, before they can authenticate.
+	ErrNoFactorsEnrolled = errors.New("MFA required but no factors are enrolled, enroll a factor in Okta")
+
+	// ErrNoFactorsAvailable is returned when MFA is required but Okta
+	// offered no factors with which to satisfy it
+	ErrNoFactorsAvailable = errors.New("MFA required but no factors are available")
+
+	// ErrMFARequiredButNoHandler is returned when MFA is required to
+	// authenticate but no `Multifactor` was given to select a factor or
+	// to read its code
+	ErrMFARequiredButNoHandler = errors.New("MFA required but no Multifactor was given")
+)
+
 // Factor identifies a factor
 type Factor interface {
 	ID() string
 	FactorType() string
 	Provider() string
+	Profile() FactorProfile
+
+	// Status of the factor's enrollment, ie `ACTIVE`. This is only
+	// reported for factors retrieved via `ListFactors`.
+	Status() string
+
+	// VerificationLinks are the endpoints Okta gave for verifying the
+	// factor, for callers driving non-standard factor flows themselves
+	VerificationLinks() FactorLinks
 
-	perform(*Dance, Multifactor, string) (SessionToken, error)
+	perform(context.Context, *Dance, Multifactor, string) (SessionToken, error)
 }
 
 // Multifactor responds to MFA requests
@@ -29,122 +66,662 @@ type Multifactor interface {
 	ReadCode(Factor) (string, error)
 }
 
+// MultifactorFunc adapts functions to a `Multifactor`, ie to inline a
+// simple selector without declaring a named type. A nil SelectFunc selects
+// the first factor offered.
+type MultifactorFunc struct {
+	SelectFunc   func([]Factor) (Factor, error)
+	ReadCodeFunc func(Factor) (string, error)
+}
+
+// Select calls SelectFunc
+func (m MultifactorFunc) Select(factors []Factor) (Factor, error) {
+	if m.SelectFunc == nil {
+		if len(factors) == 0 {
+			return nil, ErrNoFactorsAvailable
+		}
+		return factors[0], nil
+	}
+	return m.SelectFunc(factors)
+}
+
+// ReadCode calls ReadCodeFunc
+func (m MultifactorFunc) ReadCode(factor Factor) (string, error) {
+	if m.ReadCodeFunc == nil {
+		return "", errors.New("MultifactorFunc has no ReadCodeFunc")
+	}
+	return m.ReadCodeFunc(factor)
+}
+
+// PushChallengeDisplayer may be implemented by a `Multifactor` to support
+// Okta Verify number matching. When a push requires the user to select a
+// number on their device, DisplayPushChallenge is called with that number
+// so it can be shown to the user.
+type PushChallengeDisplayer interface {
+	DisplayPushChallenge(number string)
+}
+
+// PushPendingObserver may be implemented by a `Multifactor` to give the
+// user feedback, such as a spinner, while waiting for them to respond to
+// a push notification. OnPushPending is called after each poll which
+// finds the push still pending, with the time elapsed since it was sent.
+type PushPendingObserver interface {
+	OnPushPending(elapsed time.Duration)
+}
+
+// U2FSigner may be implemented by a `Multifactor` to support U2F security
+// keys. SignU2F is called with the challenge issued by Okta, and should
+// have the key sign it, returning the resulting assertion. U2F factors
+// fail if the `Multifactor` does not implement it.
+type U2FSigner interface {
+	SignU2F(challenge U2FChallenge) (U2FAssertion, error)
+}
+
+// U2FChallenge is a challenge to be signed by a U2F security key
+type U2FChallenge struct {
+	AppID        string
+	Version      string
+	CredentialID string
+	Nonce        string
+}
+
+// U2FAssertion is the response from a U2F security key to a `U2FChallenge`,
+// each field as returned by the key
+type U2FAssertion struct {
+	ClientData    string
+	SignatureData string
+}
+
+// TransactionExpiryObserver may be implemented by a `Multifactor` to learn
+// when the authn transaction expires, which is how long the user has to
+// complete MFA. TransactionExpiresAt is called before a factor is selected.
+type TransactionExpiryObserver interface {
+	TransactionExpiresAt(time.Time)
+}
+
+// IncorrectCodeNotifier may be implemented by a `Multifactor` to tell the
+// user their code was wrong. IncorrectCode is called before the code is
+// asked for again.
+type IncorrectCodeNotifier interface {
+	IncorrectCode(Factor)
+}
+
+// offeredFactor gives the factor among those offered which has the same
+// ID as f, or nil if f was not offered
+func offeredFactor(factors []Factor, f Factor) Factor {
+	for _, o := range factors {
+		if o.ID() == f.ID() {
+			return o
+		}
+	}
+	return nil
+}
+
+// factorMethods maps factor types to the methods, per RFC 8176, for
+// authenticating with them
+var factorMethods = map[string]AuthMethod{
+	"push":                AuthMethodSoftwareKey,
+	"token:software:totp": AuthMethodOTP,
+	"token:hotp":          AuthMethodOTP,
+	"token":               AuthMethodOTP,
+	"sms":                 AuthMethodSMS,
+	"call":                AuthMethodTelephone,
+	"email":               AuthMethodEmail,
+	"question":            AuthMethodKnowledge,
+	"token:hardware":      AuthMethodHardwareKey,
+	"u2f":                 AuthMethodHardwareKey,
+	"webauthn":            AuthMethodHardwareKey,
+}
+
+// authMethods gives the methods for authenticating with a password and,
+// if not nil, the factor
+func authMethods(f Factor) []AuthMethod {
+	if f == nil {
+		return []AuthMethod{AuthMethodPassword}
+	}
+	if m, ok := factorMethods[f.FactorType()]; ok {
+		return []AuthMethod{AuthMethodPassword, m, AuthMethodMFA}
+	}
+	return []AuthMethod{AuthMethodPassword, AuthMethodMFA}
+}
+
+// FactorProfile holds the profile details Okta reports for a factor.
+// Phone numbers and email addresses are already redacted by Okta, ie
+// `+1 XXX-XXX-1234`, and are suitable for display to the user.
+type FactorProfile struct {
+	CredentialID string
+	AppID        string
+	Version      string
+	PhoneNumber  string
+	Email        string
+	Name         string
+	DeviceType   string
+	Platform     string
+}
+
+// FactorLinks holds the endpoints Okta gave for a factor. Each is empty
+// if Okta did not include it.
+type FactorLinks struct {
+	// Verify is the endpoint to verify the factor
+	Verify string
+
+	// Complete is where a verification hosted by a third party, ie Duo,
+	// is completed
+	Complete string
+}
+
 type factor struct {
 	id, provider, factorType string
+	profile                  FactorProfile
+	status                   string
+	links                    FactorLinks
 }
 
-func (f factor) ID() string         { return f.id }
-func (f factor) Provider() string   { return f.provider }
-func (f factor) FactorType() string { return f.factorType }
+func (f factor) ID() string             { return f.id }
+func (f factor) Provider() string       { return f.provider }
+func (f factor) FactorType() string     { return f.factorType }
+func (f factor) Profile() FactorProfile { return f.profile }
+func (f factor) Status() string         { return f.status }
+
+func (f factor) VerificationLinks() FactorLinks { return f.links }
 
 func (o oktaUserAuthnFactor) factor() Factor {
-	if o.FactorType == "push" {
-		return pushFactor{factor{o.ID, o.Provider, o.FactorType}}
-	} else {
-		return inputFactor{factor{o.ID, o.Provider, o.FactorType}}
+	links := FactorLinks{
+		Verify:   o.Links.Verify.Href,
+		Complete: o.Embedded.Verification.Links.Complete.Href,
+	}
+	f := factor{o.ID, o.Provider, o.FactorType, o.Profile.profile(), o.Status, links}
+	switch o.FactorType {
+	case "push":
+		return pushFactor{f}
+	case "u2f":
+		return u2fFactor{f}
+	default:
+		return inputFactor{f}
+	}
+}
+
+func (p oktaUserAuthnFactorProfile) profile() FactorProfile {
+	return FactorProfile{
+		CredentialID: p.CredentialID,
+		AppID:        p.AppID,
+		Version:      p.Version,
+		PhoneNumber:  p.PhoneNumber,
+		Email:        p.Email,
+		Name:         p.Name,
+		DeviceType:   p.DeviceType,
+		Platform:     p.Platform,
+	}
+}
+
+// WithRaceFactors changes how `Authenticate` handles a user with more
+// than one push capable factor. Rather than asking the `Multifactor` to
+// select one, a push is sent to every device and the session token from
+// whichever is approved first is used, cancelling the rest.
+func WithRaceFactors() Option {
+	return option(func(d *Dance) {
+		d.raceFactors = true
+	})
+}
+
+// FactorPreference identifies a factor by its type and provider, ie
+// `{"token:software:totp", "GOOGLE"}`. An empty Provider matches a
+// factor of the type from any provider.
+type FactorPreference struct {
+	FactorType string
+	Provider   string
+}
+
+func (p FactorPreference) matches(f Factor) bool {
+	return f.FactorType() == p.FactorType && (p.Provider == "" || f.Provider() == p.Provider)
+}
+
+// SelectFactor returns the factor matching the earliest of the preferences,
+// or nil if none match. It may be used by `Multifactor` implementations to
+// select a factor without asking the user.
+func SelectFactor(factors []Factor, prefs ...FactorPreference) Factor {
+	for _, p := range prefs {
+		for _, f := range factors {
+			if p.matches(f) {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// WithFactorPreference makes `Authenticate` choose the factor matching
+// the earliest of the preferences when a user has more than one,
+// rather than asking the `Multifactor` to select one. If none match,
+// the `Multifactor` is asked as usual.
+func WithFactorPreference(prefs ...FactorPreference) Option {
+	return option(func(d *Dance) {
+		d.factorPrefs = prefs
+	})
+}
+
+// pushFactors filters factors down to those which are push notifications
+func pushFactors(factors []Factor) []Factor {
+	pushes := []Factor{}
+	for _, f := range factors {
+		if _, ok := f.(pushFactor); ok {
+			pushes = append(pushes, f)
+		}
 	}
+	return pushes
+}
+
+// raceFactors performs each of the factors concurrently, returning the
+// session token from the first to succeed, along with that factor, and
+// cancelling the rest. If every factor fails, the first error is returned.
+func raceFactors(ctx context.Context, d *Dance, m Multifactor, stateToken string, factors []Factor) (SessionToken, Factor, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		token  SessionToken
+		factor Factor
+		err    error
+	}
+	rc := make(chan result, len(factors))
+	for _, f := range factors {
+		go func(f Factor) {
+			token, err := f.perform(ctx, d, m, stateToken)
+			rc <- result{token, f, err}
+		}(f)
+	}
+
+	var firstErr error
+	for range factors {
+		r := <-rc
+		if r.err == nil {
+			return r.token, r.factor, nil
+		}
+		if firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	return "", nil, firstErr
 }
 
 type inputFactor struct {
 	factor
 }
 
-func (f inputFactor) perform(d *Dance, m Multifactor, stateToken string) (SessionToken, error) {
-	for {
-		vu := fmt.Sprintf("https://%s/api/v1/authn/factors/%s/verify", d.oktaDomain, f.ID())
-		req, err := http.NewRequest("POST", vu, nil)
-		if err != nil {
-			return "", err
-		}
+// DefaultMaxCodeAttempts is how many times a code is asked for before
+// giving up, unless overridden via `WithMaxCodeAttempts`
+const DefaultMaxCodeAttempts = 3
+
+// WithMaxCodeAttempts sets how many times the `Multifactor` is asked for
+// a code when the user enters a wrong one, after which `Authenticate`
+// fails with `ErrTooManyAttempts`. The default is `DefaultMaxCodeAttempts`.
+func WithMaxCodeAttempts(n int) Option {
+	return option(func(d *Dance) {
+		d.maxCodeAttempts = n
+	})
+}
 
+// retryableCodeErrors are the Okta error codes for a wrong code, after
+// which the transaction remains valid and another code may be tried
+var retryableCodeErrors = map[string]bool{
+	"E0000068": true, // Invalid Passcode/Answer
+	"E0000082": true, // passcode already used
+}
+
+// wrongCode reports whether the error is for an incorrect code
+func wrongCode(err error) bool {
+	oe := &OktaError{}
+	return errors.As(err, &oe) && retryableCodeErrors[oe.ErrorCode]
+}
+
+func (f inputFactor) perform(ctx context.Context, d *Dance, m Multifactor, stateToken string) (SessionToken, error) {
+	failures := 0
+	for attempt := 0; ; attempt++ {
 		code, err := m.ReadCode(f)
 		if err != nil {
 			return "", fmt.Errorf("error reading MFA input: %w", err)
 		}
 
-		state := map[string]interface{}{
+		auth, err := d.verify(ctx, f.ID(), map[string]interface{}{
 			"stateToken": stateToken,
 			"passCode":   code,
+		})
+		if wrongCode(err) {
+			// the transaction is still valid, so ask again
+			failures++
+			if failures >= d.maxCodeAttempts {
+				return "", fmt.Errorf("%w: %d incorrect codes", ErrTooManyAttempts, failures)
+			}
+			if icn, ok := m.(IncorrectCodeNotifier); ok {
+				icn.IncorrectCode(f)
+			}
+			continue
 		}
-		buf, err := json.Marshal(state)
 		if err != nil {
 			return "", err
 		}
-		req.Header.Add("Content-type", "application/json")
-		req.Header.Add("Accept", "application/json")
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
 
-		d.pre("performMFA", req)
-		res, err := d.httpClient.Do(req)
+		if auth.Status == StatusSuccess {
+			return SessionToken(auth.SessionToken), nil
+		}
+		if auth.Status != StatusMFAChallenge {
+			return "", fmt.Errorf("unexpected status: %s", auth.Status)
+		}
+		stateToken = auth.StateToken
+		err = d.pollWait(ctx, attempt)
 		if err != nil {
 			return "", err
 		}
-		defer res.Body.Close()
-		d.post("performMFA", res)
+	}
+}
+
+type pushFactor struct {
+	factor
+}
 
-		buf, err = ioutil.ReadAll(res.Body)
+func (f pushFactor) perform(ctx context.Context, d *Dance, m Multifactor, stateToken string) (SessionToken, error) {
+	displayed := 0
+	start := d.now()
+	vu := d.verifyURL(f.ID())
+	for attempt := 0; ; attempt++ {
+		auth, err := d.verifyAt(ctx, vu, map[string]interface{}{
+			"stateToken": stateToken,
+		})
+		oe := &OktaError{}
+		if err != nil && !errors.As(err, &oe) {
+			return "", &PushPollError{Attempt: attempt, Elapsed: d.now().Sub(start), Err: err}
+		}
 		if err != nil {
 			return "", err
 		}
 
-		auth := oktaUserAuthn{}
-		json.Unmarshal(buf, &auth)
-		if auth.Status == "SUCCESS" {
+		if auth.Status == StatusSuccess {
 			return SessionToken(auth.SessionToken), nil
 		}
-		if auth.Status != "MFA_CHALLENGE" {
-			return "", errors.New(string(buf))
+		answer := auth.Embedded.Factor.Embedded.Challenge.CorrectAnswer
+		if pcd, ok := m.(PushChallengeDisplayer); ok && answer != 0 && answer != displayed {
+			pcd.DisplayPushChallenge(strconv.Itoa(answer))
+			displayed = answer
+		}
+		// the factorResult may be terminal even though the status
+		// remains MFA_CHALLENGE, so both must be considered
+		switch auth.FactorResult {
+		case FactorResultRejected:
+			return "", ErrPushRejected
+		case FactorResultTimeout:
+			return "", ErrPushTimeout
+		case "", FactorResultWaiting, FactorResultSuccess:
+		default:
+			return "", fmt.Errorf("push failed: %s", auth.FactorResult)
+		}
+		if auth.Status != StatusMFAChallenge {
+			return "", fmt.Errorf("unexpected status: %s", auth.Status)
+		}
+		if ppo, ok := m.(PushPendingObserver); ok {
+			ppo.OnPushPending(d.now().Sub(start))
 		}
 		stateToken = auth.StateToken
-		time.Sleep(2 * time.Second)
+		if poll := auth.pollURL(); poll != "" {
+			vu = poll
+		}
+		err = d.pollWait(ctx, attempt)
+		if err != nil {
+			return "", &PushPollError{Attempt: attempt, Elapsed: d.now().Sub(start), Err: err}
+		}
 	}
 }
 
-type pushFactor struct {
+// PushPollError is returned when waiting on a push fails for reasons of
+// our own, such as a network error or the context being done, rather than
+// anything the user did. It wraps the cause, so `errors.Is(err,
+// context.DeadlineExceeded)` still works. A push which Okta reports as
+// expired is `ErrPushTimeout` instead.
+type PushPollError struct {
+	// Attempt is the number of polls made before the failure
+	Attempt int
+
+	// Elapsed is the time since the push was sent
+	Elapsed time.Duration
+
+	Err error
+}
+
+func (e *PushPollError) Error() string {
+	return fmt.Sprintf("error polling push after %d polls (%s): %v", e.Attempt, e.Elapsed, e.Err)
+}
+
+func (e *PushPollError) Unwrap() error {
+	return e.Err
+}
+
+type u2fFactor struct {
 	factor
 }
 
-func (f pushFactor) perform(d *Dance, _ Multifactor, stateToken string) (SessionToken, error) {
-	for {
-		vu := fmt.Sprintf("https://%s/api/v1/authn/factors/%s/verify", d.oktaDomain, f.ID())
-		req, err := http.NewRequest("POST", vu, nil)
-		if err != nil {
-			return "", err
-		}
+func (f u2fFactor) perform(ctx context.Context, d *Dance, m Multifactor, stateToken string) (SessionToken, error) {
+	signer, ok := m.(U2FSigner)
+	if !ok {
+		return "", fmt.Errorf("MFA factor %s requires a Multifactor which implements U2FSigner", f.FactorType())
+	}
 
-		state := map[string]interface{}{
-			"stateToken": stateToken,
+	auth, err := d.verify(ctx, f.ID(), map[string]interface{}{
+		"stateToken": stateToken,
+	})
+	if err != nil {
+		return "", err
+	}
+	if auth.Status != StatusMFAChallenge {
+		return "", fmt.Errorf("unexpected status: %s", auth.Status)
+	}
+
+	of := auth.Embedded.Factor
+	assertion, err := signer.SignU2F(U2FChallenge{
+		AppID:        of.Profile.AppID,
+		Version:      of.Profile.Version,
+		CredentialID: of.Profile.CredentialID,
+		Nonce:        of.Embedded.Challenge.Nonce,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error signing U2F challenge: %w", err)
+	}
+
+	auth, err = d.verify(ctx, f.ID(), map[string]interface{}{
+		"stateToken":    auth.StateToken,
+		"clientData":    assertion.ClientData,
+		"signatureData": assertion.SignatureData,
+	})
+	if err != nil {
+		return "", err
+	}
+	if auth.Status != StatusSuccess {
+		return "", fmt.Errorf("unexpected status: %s", auth.Status)
+	}
+	return SessionToken(auth.SessionToken), nil
+}
+
+// BackoffFunc gives how long to wait before the next poll of a pending
+// MFA challenge, given the number of polls made so far (starting at 0)
+type BackoffFunc func(attempt int) time.Duration
+
+// DefaultPollBackoff polls every two seconds
+var DefaultPollBackoff = ConstantBackoff(2 * time.Second)
+
+// ConstantBackoff waits the same interval between every poll
+func ConstantBackoff(interval time.Duration) BackoffFunc {
+	return func(int) time.Duration {
+		return interval
+	}
+}
+
+// ExponentialBackoff starts at the initial interval and doubles it
+// after each poll, up to max
+func ExponentialBackoff(initial, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		wait := initial
+		for i := 0; i < attempt && wait < max; i++ {
+			wait *= 2
 		}
-		buf, err := json.Marshal(state)
-		if err != nil {
-			return "", err
+		if wait > max {
+			wait = max
 		}
-		req.Header.Add("Content-type", "application/json")
-		req.Header.Add("Accept", "application/json")
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
+		return wait
+	}
+}
 
-		d.pre("performMFA", req)
-		res, err := d.httpClient.Do(req)
-		if err != nil {
-			return "", err
-		}
-		defer res.Body.Close()
-		d.post("performMFA", res)
+// WithPollBackoff sets how long to wait between polls while an MFA
+// challenge, such as a push, is pending. The default is
+// `DefaultPollBackoff`.
+func WithPollBackoff(backoff BackoffFunc) Option {
+	return option(func(d *Dance) {
+		d.pollBackoff = backoff
+	})
+}
 
-		buf, err = ioutil.ReadAll(res.Body)
-		if err != nil {
-			return "", err
-		}
+// DefaultPollJitter randomly varies each poll interval by up to 10%
+const DefaultPollJitter = 0.1
 
-		auth := oktaUserAuthn{}
-		json.Unmarshal(buf, &auth)
-		if auth.Status == "SUCCESS" {
-			return SessionToken(auth.SessionToken), nil
+// Jitter randomly varies the intervals given by backoff by up to the
+// fraction either way, ie 0.1 for ±10%
+func Jitter(backoff BackoffFunc, fraction float64) BackoffFunc {
+	return func(attempt int) time.Duration {
+		wait := backoff(attempt)
+		if fraction <= 0 {
+			return wait
 		}
-		if auth.Status != "MFA_CHALLENGE" {
-			return "", errors.New(string(buf))
-		}
-		stateToken = auth.StateToken
-		time.Sleep(2 * time.Second)
+		delta := (rand.Float64()*2 - 1) * fraction * float64(wait)
+		return wait + time.Duration(delta)
+	}
+}
+
+// WithPollJitter sets the fraction by which poll intervals are randomly
+// varied, so that many clients polling at once, ie after a fleet restart,
+// do not fall into step and hammer Okta together. The default is
+// `DefaultPollJitter`, 0 disables it.
+func WithPollJitter(fraction float64) Option {
+	return option(func(d *Dance) {
+		d.pollJitter = fraction
+	})
+}
+
+// pollWait waits before the next poll, returning early with an
+// error if the context is done
+func (d *Dance) pollWait(ctx context.Context, attempt int) error {
+	select {
+	case <-d.after(Jitter(d.pollBackoff, d.pollJitter)(attempt)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// verify posts to the verify endpoint of a factor
+func (d *Dance) verify(ctx context.Context, factorID string, payload map[string]interface{}) (oktaUserAuthn, error) {
+	return d.verifyAt(ctx, d.verifyURL(factorID), payload)
+}
+
+// verifyURL is the endpoint to verify the factor
+func (d *Dance) verifyURL(factorID string) string {
+	return fmt.Sprintf("https://%s/api/v1/authn/factors/%s/verify", d.oktaDomain, url.PathEscape(factorID))
+}
+
+// verifyAt verifies a factor at the given endpoint, ie the poll link
+// from a previous response
+func (d *Dance) verifyAt(ctx context.Context, vu string, payload map[string]interface{}) (oktaUserAuthn, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return oktaUserAuthn{}, err
+	}
+	return d.authnStep(ctx, "performMFA", vu, body)
+}
+
+// VerifyResult is the state of an authn transaction after
+// verifying a factor with `VerifyFactor`
+type VerifyResult struct {
+	// Status of the transaction, ie `StatusSuccess` or `StatusMFAChallenge`
+	Status AuthnStatus
+
+	// StateToken to use for further steps of the transaction
+	StateToken string
+
+	// SessionToken, once Status is `SUCCESS`
+	SessionToken SessionToken
+
+	// FactorResult is the outcome of the verification, if
+	// still pending, ie `WAITING` or `REJECTED`
+	FactorResult FactorResult
+}
+
+// VerifyFactor submits a code for a factor in an authn transaction,
+// given the transaction's stateToken and the factor's ID. This is the
+// single step performed for code based factors during `Authenticate`,
+// for applications which drive their own MFA UI rather than implement
+// `Multifactor`. A rejected code is reported as an `*OktaError`.
+func (d *Dance) VerifyFactor(ctx context.Context, stateToken, factorID, code string) (*VerifyResult, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	auth, err := d.verify(ctx, factorID, map[string]interface{}{
+		"stateToken": stateToken,
+		"passCode":   code,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyResult{
+		Status:       auth.Status,
+		StateToken:   auth.StateToken,
+		SessionToken: SessionToken(auth.SessionToken),
+		FactorResult: auth.FactorResult,
+	}, nil
+}
+
+// TriggerPush sends a push notification for a factor in an authn
+// transaction and returns without waiting on the user, for applications
+// which drive their own polling via `PollPush`, ie from an event loop.
+// The returned pollToken is the stateToken to poll with.
+func (d *Dance) TriggerPush(ctx context.Context, stateToken, factorID string) (pollToken string, err error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	auth, err := d.verify(ctx, factorID, map[string]interface{}{
+		"stateToken": stateToken,
+	})
+	if err != nil {
+		return "", err
+	}
+	if auth.Status != StatusMFAChallenge && auth.Status != StatusSuccess {
+		return "", fmt.Errorf("unexpected status: %s", auth.Status)
+	}
+	return auth.StateToken, nil
+}
+
+// PollPush checks once on a push sent by `TriggerPush`. While the user has
+// yet to respond the result is `FactorResultWaiting`; once they approve it
+// is `FactorResultSuccess` along with the sessionToken. A rejected or
+// expired push is reported as `ErrPushRejected` or `ErrPushTimeout`,
+// along with the result.
+func (d *Dance) PollPush(ctx context.Context, stateToken, factorID string) (FactorResult, SessionToken, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	auth, err := d.verify(ctx, factorID, map[string]interface{}{
+		"stateToken": stateToken,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if auth.Status == StatusSuccess {
+		return FactorResultSuccess, SessionToken(auth.SessionToken), nil
+	}
+	switch auth.FactorResult {
+	case FactorResultRejected:
+		return auth.FactorResult, "", ErrPushRejected
+	case FactorResultTimeout:
+		return auth.FactorResult, "", ErrPushTimeout
+	case "", FactorResultWaiting:
+		return FactorResultWaiting, "", nil
 	}
+	return auth.FactorResult, "", fmt.Errorf("push failed: %s", auth.FactorResult)
 }
@@ -1,6 +1,7 @@
 package oktadance
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -19,34 +20,89 @@ func NewConsoleMultifactor() (*ConsoleMultifactor, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &ConsoleMultifactor{l}, nil
+	return &ConsoleMultifactor{Instance: l}, nil
 }
 
-// ConsoleMultifactor handles the user input
+// ConsoleMultifactor handles the user input. As it owns the
+// terminal it is not safe for concurrent use.
 type ConsoleMultifactor struct {
 	*readline.Instance
+
+	// Preferences, if set, select a factor matching the earliest of
+	// them without asking the user. See `SelectFactor`.
+	Preferences []FactorPreference
+}
+
+// Close releases the terminal. The ConsoleMultifactor is unusable after
+// it has been closed.
+func (c *ConsoleMultifactor) Close() error {
+	return c.Instance.Close()
 }
 
 // RequestUsernamePassword asks the user for their username and password
 func (c *ConsoleMultifactor) RequestUsernamePassword() (username, password string, err error) {
+	return c.RequestUsernamePasswordContext(context.Background())
+}
+
+// RequestUsernamePasswordContext asks the user for their username and password,
+// giving up when the context is done. As the only way to interrupt a pending
+// read is to close the console, the ConsoleMultifactor is unusable after the
+// context has been cancelled.
+func (c *ConsoleMultifactor) RequestUsernamePasswordContext(ctx context.Context) (username, password string, err error) {
 	c.SetPrompt("username: ")
-	username, err = c.Readline()
+	username, err = c.readContext(ctx, c.Readline)
 	if err != nil {
 		return "", "", err
 	}
 	username = strings.TrimSpace(username)
 
-	pass, err := c.ReadPassword("password: ")
+	password, err = c.readContext(ctx, func() (string, error) {
+		pass, err := c.ReadPassword("password: ")
+		return string(pass), err
+	})
 	if err != nil {
 		return "", "", err
 	}
-	password = strings.TrimSpace(string(pass))
+	password = strings.TrimSpace(password)
 
 	return username, password, nil
 }
 
+// readContext runs read, closing the console if the context is done first
+func (c *ConsoleMultifactor) readContext(ctx context.Context, read func() (string, error)) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if ctx.Done() == nil {
+		return read()
+	}
+
+	type result struct {
+		line string
+		err  error
+	}
+	rc := make(chan result, 1)
+	go func() {
+		line, err := read()
+		rc <- result{line, err}
+	}()
+
+	select {
+	case r := <-rc:
+		return r.line, r.err
+	case <-ctx.Done():
+		// Close waits for the pending read to finish, so it must not
+		// hold up giving up on it
+		go c.Instance.Close()
+		return "", ctx.Err()
+	}
+}
+
 // Select the factor to use for the challenge
 func (c *ConsoleMultifactor) Select(factors []Factor) (Factor, error) {
+	if f := SelectFactor(factors, c.Preferences...); f != nil {
+		return f, nil
+	}
 	for {
 		fm := map[int]Factor{}
 		options := []readline.PrefixCompleterInterface{}
@@ -56,7 +112,7 @@ func (c *ConsoleMultifactor) Select(factors []Factor) (Factor, error) {
 			options = append(options, readline.PcItem(f.FactorType()))
 			fs = append(fs, strconv.Itoa(i))
 			fm[i] = f
-			fmt.Printf("  %d\t%s (%s)\n", i, f.FactorType(), f.Provider())
+			fmt.Printf("  %d\t%s (%s)%s\n", i, f.FactorType(), f.Provider(), profileHint(f.Profile()))
 		}
 
 		completer := readline.NewPrefixCompleter(options...)
@@ -91,3 +147,26 @@ func (c *ConsoleMultifactor) ReadCode(Factor) (string, error) {
 	}
 	return strings.TrimSpace(code), nil
 }
+
+// IncorrectCode tells the user to try again after a wrong code
+func (c *ConsoleMultifactor) IncorrectCode(Factor) {
+	fmt.Printf("incorrect code, try again\n")
+}
+
+// profileHint describes where a factor will send its challenge, if known
+func profileHint(p FactorProfile) string {
+	switch {
+	case p.PhoneNumber != "":
+		return " " + p.PhoneNumber
+	case p.Email != "":
+		return " " + p.Email
+	case p.Name != "":
+		return " " + p.Name
+	}
+	return ""
+}
+
+// DisplayPushChallenge tells the user which number to select in Okta Verify
+func (c *ConsoleMultifactor) DisplayPushChallenge(number string) {
+	fmt.Printf("select %s in Okta Verify to approve the push\n", number)
+}
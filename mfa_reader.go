@@ -0,0 +1,112 @@
+package oktadance
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// NewReaderMultifactor creates a `Multifactor` which reads credentials,
+// factor selections, and MFA codes, one per line, from `in` rather than
+// from a terminal. Prompts are written to `out`, which may be nil.
+//
+// This is suitable for pipes, CI, or credentials stored in a file.
+func NewReaderMultifactor(in io.Reader, out io.Writer) *ReaderMultifactor {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	return &ReaderMultifactor{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// ReaderMultifactor handles user input from an `io.Reader`
+type ReaderMultifactor struct {
+	// Username, if set, is used rather than reading one from input
+	Username string
+
+	// Password, if set, is used rather than reading one from input
+	Password string
+
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// RequestUsernamePassword returns the preset username and password,
+// reading from input any which were not set
+func (r *ReaderMultifactor) RequestUsernamePassword() (username, password string, err error) {
+	username = r.Username
+	if username == "" {
+		username, err = r.readLine("username: ")
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	password = r.Password
+	if password == "" {
+		password, err = r.readLine("password: ")
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return username, password, nil
+}
+
+// Select the factor to use for the challenge. The input line may be either
+// the index of the factor or its factor type.
+func (r *ReaderMultifactor) Select(factors []Factor) (Factor, error) {
+	fmt.Fprintf(r.out, "select factor:\n")
+	for i, f := range factors {
+		fmt.Fprintf(r.out, "  %d\t%s (%s)%s\n", i, f.FactorType(), f.Provider(), profileHint(f.Profile()))
+	}
+
+	choice, err := r.readLine("factor: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if idx, err := strconv.Atoi(choice); err == nil {
+		if idx < 0 || idx >= len(factors) {
+			return nil, fmt.Errorf("%d is not an available factor", idx)
+		}
+		return factors[idx], nil
+	}
+
+	for _, f := range factors {
+		if f.FactorType() == choice {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not an available factor", choice)
+}
+
+// ReadCode reads the MFA code from input
+func (r *ReaderMultifactor) ReadCode(Factor) (string, error) {
+	return r.readLine("code: ")
+}
+
+// IncorrectCode writes a message asking the user to try again to the output
+func (r *ReaderMultifactor) IncorrectCode(Factor) {
+	fmt.Fprintf(r.out, "incorrect code, try again\n")
+}
+
+// DisplayPushChallenge writes the number to select in Okta Verify to the output
+func (r *ReaderMultifactor) DisplayPushChallenge(number string) {
+	fmt.Fprintf(r.out, "select %s in Okta Verify to approve the push\n", number)
+}
+
+// readLine writes the prompt and reads the next line of input
+func (r *ReaderMultifactor) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	line, err := r.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
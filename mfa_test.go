@@ -0,0 +1,1407 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFactor_Profile(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{
+						"id":         "sms1",
+						"factorType": "sms",
+						"provider":   "OKTA",
+						"profile":    map[string]interface{}{"phoneNumber": "+1 XXX-XXX-1234"},
+					},
+					{
+						"id":         "email1",
+						"factorType": "email",
+						"provider":   "OKTA",
+						"profile":    map[string]interface{}{"email": "b...n@example.com"},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/sms1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	var seen []oktadance.FactorProfile
+	mfa := oktadance.MultifactorFunc{
+		SelectFunc: func(factors []oktadance.Factor) (oktadance.Factor, error) {
+			for _, f := range factors {
+				seen = append(seen, f.Profile())
+			}
+			return factors[0], nil
+		},
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}
+
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(err)
+	assert.Equal(oktadance.SessionToken("token"), token)
+
+	require.Len(seen, 2)
+	assert.Equal("+1 XXX-XXX-1234", seen[0].PhoneNumber)
+	assert.Equal("b...n@example.com", seen[1].Email)
+}
+
+func TestReaderMultifactor(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mfa := oktadance.NewReaderMultifactor(strings.NewReader("user\npass\nsms\n123456"), nil)
+
+	username, password, err := mfa.RequestUsernamePassword()
+	require.NoError(err)
+	assert.Equal("user", username)
+	assert.Equal("pass", password)
+
+	var gotCode string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+					{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/sms1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotCode = body["passCode"]
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	token, err := d.Authenticate(context.Background(), username, password, mfa)
+	require.NoError(err)
+	assert.Equal(oktadance.SessionToken("token"), token)
+	assert.Equal("123456", gotCode)
+}
+
+func TestReaderMultifactor_Preset(t *testing.T) {
+	mfa := oktadance.NewReaderMultifactor(strings.NewReader(""), nil)
+	mfa.Username = "user"
+	mfa.Password = "pass"
+
+	username, password, err := mfa.RequestUsernamePassword()
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+}
+
+func TestPushFactor_FactorResult(t *testing.T) {
+	for _, tc := range []struct {
+		factorResult string
+		want         error
+	}{
+		{"REJECTED", oktadance.ErrPushRejected},
+		{"TIMEOUT", oktadance.ErrPushTimeout},
+	} {
+		t.Run(tc.factorResult, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"stateToken": "state",
+					"status":     "MFA_REQUIRED",
+					"_embedded": map[string]interface{}{
+						"factors": []map[string]interface{}{
+							{"id": "push1", "factorType": "push", "provider": "OKTA"},
+						},
+					},
+				})
+			})
+			mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"stateToken":   "state",
+					"status":       "MFA_CHALLENGE",
+					"factorResult": tc.factorResult,
+				})
+			})
+			d, srv := mockOkta(t, mux)
+			defer srv.Close()
+
+			_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+			assert.Equal(t, tc.want, err)
+		})
+	}
+}
+
+type challengeMFA struct {
+	oktadance.MultifactorFunc
+	numbers []string
+}
+
+func (c *challengeMFA) DisplayPushChallenge(number string) {
+	c.numbers = append(c.numbers, number)
+}
+
+func TestPushFactor_NumberChallenge(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state",
+			"status":       "MFA_CHALLENGE",
+			"factorResult": "REJECTED",
+			"_embedded": map[string]interface{}{
+				"factor": map[string]interface{}{
+					"_embedded": map[string]interface{}{
+						"challenge": map[string]interface{}{"correctAnswer": 42},
+					},
+				},
+			},
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	mfa := &challengeMFA{}
+	_, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	assert.Equal(t, oktadance.ErrPushRejected, err)
+	assert.Equal(t, []string{"42"}, mfa.numbers)
+}
+
+type u2fMFA struct {
+	oktadance.MultifactorFunc
+	challenge oktadance.U2FChallenge
+}
+
+func (u *u2fMFA) SignU2F(challenge oktadance.U2FChallenge) (oktadance.U2FAssertion, error) {
+	u.challenge = challenge
+	return oktadance.U2FAssertion{ClientData: "client", SignatureData: "signature"}, nil
+}
+
+func TestU2FFactor(t *testing.T) {
+	var signed map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "u2f1", "factorType": "u2f", "provider": "FIDO"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/u2f1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["signatureData"] == "" {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"stateToken": "state2",
+				"status":     "MFA_CHALLENGE",
+				"_embedded": map[string]interface{}{
+					"factor": map[string]interface{}{
+						"profile": map[string]interface{}{
+							"credentialId": "cred",
+							"appId":        "https://example.okta.com",
+							"version":      "U2F_V2",
+						},
+						"_embedded": map[string]interface{}{
+							"challenge": map[string]interface{}{"nonce": "nonce"},
+						},
+					},
+				},
+			})
+			return
+		}
+		signed = body
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", oktadance.MultifactorFunc{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "U2FSigner")
+
+	mfa := &u2fMFA{}
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Equal(t, oktadance.U2FChallenge{
+		AppID:        "https://example.okta.com",
+		Version:      "U2F_V2",
+		CredentialID: "cred",
+		Nonce:        "nonce",
+	}, mfa.challenge)
+	assert.Equal(t, map[string]string{
+		"stateToken":    "state2",
+		"clientData":    "client",
+		"signatureData": "signature",
+	}, signed)
+}
+
+func TestDance_RaceFactors(t *testing.T) {
+	require := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "phone", "factorType": "push", "provider": "OKTA"},
+					{"id": "tablet", "factorType": "push", "provider": "OKTA"},
+					{"id": "totp", "factorType": "token:software:totp", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/phone/verify", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state",
+			"status":       "MFA_CHALLENGE",
+			"factorResult": "TIMEOUT",
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/tablet/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithRaceFactors())
+	defer srv.Close()
+
+	mfa := oktadance.MultifactorFunc{
+		SelectFunc: func([]oktadance.Factor) (oktadance.Factor, error) {
+			return nil, errors.New("should not select when racing")
+		},
+	}
+
+	start := time.Now()
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(err)
+	require.Equal(oktadance.SessionToken("token"), token)
+	require.True(time.Since(start) < 5*time.Second)
+}
+
+type expiryMFA struct {
+	oktadance.MultifactorFunc
+	expiresAt time.Time
+}
+
+func (e *expiryMFA) TransactionExpiresAt(t time.Time) {
+	e.expiresAt = t
+}
+
+func TestDance_Authenticate_TransactionExpiry(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"expiresAt":  "2015-11-03T10:15:57.000Z",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/sms1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	mfa := &expiryMFA{MultifactorFunc: oktadance.MultifactorFunc{
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}}
+	_, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2015, 11, 3, 10, 15, 57, 0, time.UTC), mfa.expiresAt)
+}
+
+func TestDance_VerifyFactor(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["passCode"] != "123456" {
+			writeJSON(w, http.StatusForbidden, map[string]interface{}{
+				"errorCode":    "E0000068",
+				"errorSummary": "Invalid Passcode/Answer",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	vr, err := d.VerifyFactor(ctx, "state", "totp1", "123456")
+	require.NoError(err)
+	assert.Equal(oktadance.StatusSuccess, vr.Status)
+	assert.Equal(oktadance.SessionToken("token"), vr.SessionToken)
+
+	_, err = d.VerifyFactor(ctx, "state", "totp1", "000000")
+	var oe *oktadance.OktaError
+	require.True(errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal("E0000068", oe.ErrorCode)
+}
+
+type pendingMFA struct {
+	oktadance.MultifactorFunc
+	pending []time.Duration
+}
+
+func (p *pendingMFA) OnPushPending(elapsed time.Duration) {
+	p.pending = append(p.pending, elapsed)
+}
+
+func TestPushFactor_Pending(t *testing.T) {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls == 1 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"stateToken":   "state",
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "WAITING",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithPollBackoff(oktadance.ConstantBackoff(time.Millisecond)))
+	defer srv.Close()
+
+	mfa := &pendingMFA{}
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Len(t, mfa.pending, 1)
+}
+
+func TestPushFactor_PollLink(t *testing.T) {
+	var base string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state",
+			"status":       "MFA_CHALLENGE",
+			"factorResult": "WAITING",
+			"_links": map[string]interface{}{
+				"next": map[string]interface{}{
+					"name": "poll",
+					"href": base + "/oauth2/custom/authn/factors/push1/verify",
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/oauth2/custom/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithPollBackoff(oktadance.ConstantBackoff(time.Millisecond)))
+	defer srv.Close()
+	base = srv.URL
+
+	token, err := d.Authenticate(context.Background(), "user", "pass", &pendingMFA{})
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+}
+
+// fakeClock records the waits asked of it, returning at once
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestDance_Clock(t *testing.T) {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls < 3 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"stateToken":   "state",
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "WAITING",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	d, srv := mockOkta(t, mux, oktadance.WithClock(clock), oktadance.WithPollBackoff(oktadance.ConstantBackoff(time.Hour)), oktadance.WithPollJitter(0))
+	defer srv.Close()
+
+	start := time.Now()
+	token, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Equal(t, []time.Duration{time.Hour, time.Hour}, clock.waits)
+	assert.True(t, time.Since(start) < time.Minute, "the waits should not take real time")
+}
+
+func TestDance_TriggerPollPush(t *testing.T) {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch polls {
+		case 1, 2:
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"stateToken":   "state",
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "WAITING",
+			})
+		case 3:
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status":       "SUCCESS",
+				"sessionToken": "token",
+			})
+		default:
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"stateToken":   "state",
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "REJECTED",
+			})
+		}
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	pollToken, err := d.TriggerPush(ctx, "state", "push1")
+	require.NoError(t, err)
+	assert.Equal(t, "state", pollToken)
+
+	result, token, err := d.PollPush(ctx, pollToken, "push1")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.FactorResultWaiting, result)
+	assert.Empty(t, token)
+
+	result, token, err = d.PollPush(ctx, pollToken, "push1")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.FactorResultSuccess, result)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+
+	result, _, err = d.PollPush(ctx, pollToken, "push1")
+	assert.Equal(t, oktadance.ErrPushRejected, err)
+	assert.Equal(t, oktadance.FactorResultRejected, result)
+}
+
+func TestDance_ResumeAuthn(t *testing.T) {
+	var steps []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		steps = append(steps, "authn "+body["stateToken"])
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_CHALLENGE",
+			"_embedded": map[string]interface{}{
+				"factor": map[string]interface{}{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/previous", func(w http.ResponseWriter, r *http.Request) {
+		steps = append(steps, "previous")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		steps = append(steps, "verify "+body["passCode"])
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	mfa := oktadance.MultifactorFunc{
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}
+	token, err := d.ResumeAuthn(context.Background(), "state", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Equal(t, []string{"authn state", "previous", "verify 123456"}, steps)
+
+	_, err = d.ResumeAuthn(context.Background(), "", mfa)
+	assert.Equal(t, oktadance.ErrEmptyStateToken, err)
+}
+
+func TestDance_AuthnState(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["stateToken"] == "challenged" {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"stateToken":   "challenged",
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "WAITING",
+				"_embedded": map[string]interface{}{
+					"factor": map[string]interface{}{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"expiresAt":  "2020-01-02T03:04:05.000Z",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+			"_links": map[string]interface{}{
+				"cancel": map[string]string{"href": "https://example.okta.com/api/v1/authn/cancel"},
+			},
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	state, err := d.AuthnState(context.Background(), "state")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusMFARequired, state.Status)
+	assert.Equal(t, "state", state.StateToken)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), state.ExpiresAt.UTC())
+	require.Len(t, state.Factors, 2)
+	assert.Equal(t, "totp1", state.Factors[0].ID())
+	assert.Equal(t, "push", state.Factors[1].FactorType())
+	assert.Nil(t, state.Factor)
+	assert.Equal(t, "https://example.okta.com/api/v1/authn/cancel", state.Links["cancel"])
+
+	state, err = d.AuthnState(context.Background(), "challenged")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusMFAChallenge, state.Status)
+	assert.Equal(t, oktadance.FactorResultWaiting, state.FactorResult)
+	require.NotNil(t, state.Factor)
+	assert.Equal(t, "push1", state.Factor.ID())
+	assert.Empty(t, state.Factors)
+
+	_, err = d.AuthnState(context.Background(), "")
+	assert.Equal(t, oktadance.ErrEmptyStateToken, err)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := oktadance.ExponentialBackoff(time.Second, 5*time.Second)
+	got := []time.Duration{}
+	for i := 0; i < 5; i++ {
+		got = append(got, backoff(i))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	}, got)
+}
+
+func TestPushFactor_PollErrorVsTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": body["password"],
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		result := "WAITING"
+		if body["stateToken"] == "expired" {
+			result = "TIMEOUT"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   body["stateToken"],
+			"status":       "MFA_CHALLENGE",
+			"factorResult": result,
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithPollBackoff(oktadance.ConstantBackoff(10*time.Millisecond)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := d.Authenticate(ctx, "user", "waiting", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.False(t, errors.Is(err, oktadance.ErrPushTimeout))
+	pe := &oktadance.PushPollError{}
+	require.True(t, errors.As(err, &pe), "unexpected error: %v", err)
+	assert.True(t, pe.Attempt > 0)
+
+	_, err = d.Authenticate(context.Background(), "user", "expired", nil)
+	assert.Equal(t, oktadance.ErrPushTimeout, err)
+}
+
+func TestDance_Authenticate_CancelsAbandonedAuthn(t *testing.T) {
+	cancelled := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state",
+			"status":       "MFA_CHALLENGE",
+			"factorResult": "WAITING",
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/cancel", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		cancelled <- body["stateToken"]
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "CANCELLED"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithPollBackoff(oktadance.ConstantBackoff(10*time.Millisecond)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := d.Authenticate(ctx, "user", "pass", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+	select {
+	case st := <-cancelled:
+		assert.Equal(t, "state", st)
+	default:
+		t.Fatal("authn transaction was not cancelled")
+	}
+}
+
+func TestDance_Authenticate_NoFactors(t *testing.T) {
+	tests := []struct {
+		status string
+		want   error
+	}{
+		{"MFA_ENROLL", oktadance.ErrNoFactorsEnrolled},
+		{"MFA_REQUIRED", oktadance.ErrNoFactorsAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"stateToken": "state",
+					"status":     tt.status,
+				})
+			})
+			d, srv := mockOkta(t, mux)
+			defer srv.Close()
+
+			_, err := d.Authenticate(context.Background(), "user", "pass", oktadance.MultifactorFunc{})
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+func TestSelectFactor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/00u1/factors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"id": "okta", "factorType": "token:software:totp", "provider": "OKTA"},
+			{"id": "google", "factorType": "token:software:totp", "provider": "GOOGLE"},
+			{"id": "sms", "factorType": "sms", "provider": "OKTA"},
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	factors, err := d.ListFactors(context.Background(), "00u1")
+	require.NoError(t, err)
+
+	f := oktadance.SelectFactor(factors, oktadance.FactorPreference{FactorType: "token:software:totp", Provider: "GOOGLE"})
+	require.NotNil(t, f)
+	assert.Equal(t, "google", f.ID())
+
+	f = oktadance.SelectFactor(factors,
+		oktadance.FactorPreference{FactorType: "push"},
+		oktadance.FactorPreference{FactorType: "sms"},
+	)
+	require.NotNil(t, f)
+	assert.Equal(t, "sms", f.ID())
+
+	f = oktadance.SelectFactor(factors, oktadance.FactorPreference{FactorType: "token:software:totp"})
+	require.NotNil(t, f)
+	assert.Equal(t, "okta", f.ID())
+
+	assert.Nil(t, oktadance.SelectFactor(factors, oktadance.FactorPreference{FactorType: "push"}))
+	assert.Nil(t, oktadance.SelectFactor(factors))
+}
+
+func TestDance_SelectFactorNotOffered(t *testing.T) {
+	verified := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		id := "sms-" + body["username"]
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": id, "factorType": "sms", "provider": "OKTA"},
+					{"id": "totp-" + body["username"], "factorType": "token:software:totp", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/", func(w http.ResponseWriter, r *http.Request) {
+		verified++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	var kept oktadance.Factor
+	mfa := oktadance.MultifactorFunc{
+		SelectFunc: func(factors []oktadance.Factor) (oktadance.Factor, error) {
+			if kept == nil {
+				kept = factors[0]
+			}
+			return kept, nil
+		},
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}
+
+	_, err := d.Authenticate(context.Background(), "alice", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, 1, verified)
+
+	// a factor from another transaction is not one offered to bob
+	_, err = d.Authenticate(context.Background(), "bob", "pass", mfa)
+	assert.EqualError(t, err, "a factor was returned which was not passed in")
+	assert.Equal(t, 1, verified)
+}
+
+func TestMultifactorFunc(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/00u1/factors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"id": "sms", "factorType": "sms", "provider": "OKTA"},
+			{"id": "totp", "factorType": "token:software:totp", "provider": "OKTA"},
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithAPIToken("secret"))
+	defer srv.Close()
+
+	factors, err := d.ListFactors(context.Background(), "00u1")
+	require.NoError(t, err)
+
+	var mfa oktadance.Multifactor = oktadance.MultifactorFunc{}
+	f, err := mfa.Select(factors)
+	require.NoError(t, err)
+	assert.Equal(t, "sms", f.ID())
+	_, err = mfa.ReadCode(f)
+	assert.Error(t, err)
+
+	mfa = oktadance.MultifactorFunc{
+		SelectFunc:   func(fs []oktadance.Factor) (oktadance.Factor, error) { return fs[1], nil },
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}
+	f, err = mfa.Select(factors)
+	require.NoError(t, err)
+	assert.Equal(t, "totp", f.ID())
+	code, err := mfa.ReadCode(f)
+	require.NoError(t, err)
+	assert.Equal(t, "123456", code)
+}
+
+func TestDance_Authenticate_FactorPreference(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+					{"id": "totp2", "factorType": "token:software:totp", "provider": "GOOGLE"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp2/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithFactorPreference(oktadance.FactorPreference{
+		FactorType: "token:software:totp",
+		Provider:   "GOOGLE",
+	}))
+	defer srv.Close()
+
+	mfa := oktadance.MultifactorFunc{
+		SelectFunc: func([]oktadance.Factor) (oktadance.Factor, error) {
+			return nil, errors.New("select should not be called")
+		},
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+}
+
+func TestDance_Authenticate_SingleTOTP(t *testing.T) {
+	var gotCode string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotCode = body["passCode"]
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	var read oktadance.Factor
+	mfa := oktadance.MultifactorFunc{
+		SelectFunc: func([]oktadance.Factor) (oktadance.Factor, error) {
+			return nil, errors.New("select should not be called for a single factor")
+		},
+		ReadCodeFunc: func(f oktadance.Factor) (string, error) {
+			read = f
+			return "654321", nil
+		},
+	}
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	require.NotNil(t, read)
+	assert.Equal(t, "totp1", read.ID())
+	assert.Equal(t, "654321", gotCode)
+}
+
+func TestDance_Authenticate_SingleFactorNoMultifactor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+				},
+			},
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no Multifactor")
+	assert.True(t, errors.Is(err, oktadance.ErrMFARequiredButNoHandler), "unexpected error: %v", err)
+}
+
+func TestDance_Authenticate_MultipleFactorsNoMultifactor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+					{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	assert.Equal(t, oktadance.ErrMFARequiredButNoHandler, err)
+}
+
+func TestDance_Authenticate_FactorPreferenceNoMultifactor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the factor should not be verified without a Multifactor")
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithFactorPreference(oktadance.FactorPreference{
+		FactorType: "token:software:totp",
+	}))
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	assert.True(t, errors.Is(err, oktadance.ErrMFARequiredButNoHandler), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "token:software:totp")
+}
+
+func TestJitter(t *testing.T) {
+	backoff := oktadance.Jitter(oktadance.ConstantBackoff(time.Second), 0.1)
+	for i := 0; i < 100; i++ {
+		wait := backoff(i)
+		assert.True(t, wait >= 900*time.Millisecond && wait <= 1100*time.Millisecond, "wait out of range: %s", wait)
+	}
+
+	backoff = oktadance.Jitter(oktadance.ConstantBackoff(time.Second), 0)
+	assert.Equal(t, time.Second, backoff(0))
+}
+
+func TestDance_Authenticate_WrongCode(t *testing.T) {
+	var states []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		states = append(states, body["stateToken"])
+		if body["passCode"] != "123456" {
+			writeJSON(w, http.StatusForbidden, map[string]interface{}{
+				"errorCode":    "E0000068",
+				"errorSummary": "Invalid Passcode/Answer",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	codes := []string{"000000", "123456"}
+	mfa := oktadance.MultifactorFunc{
+		ReadCodeFunc: func(oktadance.Factor) (string, error) {
+			code := codes[0]
+			codes = codes[1:]
+			return code, nil
+		},
+	}
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Equal(t, []string{"state", "state"}, states, "the transaction should be reused")
+}
+
+func TestDance_Authenticate_TooManyAttempts(t *testing.T) {
+	verifies := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		verifies++
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"errorCode":    "E0000068",
+			"errorSummary": "Invalid Passcode/Answer",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithMaxCodeAttempts(2))
+	defer srv.Close()
+
+	out := &strings.Builder{}
+	mfa := oktadance.NewReaderMultifactor(strings.NewReader("111111\n222222\n333333\n"), out)
+	_, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	assert.True(t, errors.Is(err, oktadance.ErrTooManyAttempts), "unexpected error: %v", err)
+	assert.Equal(t, 2, verifies)
+	assert.Equal(t, 1, strings.Count(out.String(), "incorrect code, try again"))
+}
+
+func TestPushFactor_Transitions(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		responses []map[string]interface{}
+		want      error
+	}{
+		{
+			name: "WAITING to SUCCESS",
+			responses: []map[string]interface{}{
+				{"stateToken": "state", "status": "MFA_CHALLENGE", "factorResult": "WAITING"},
+				{"stateToken": "state", "status": "MFA_CHALLENGE", "factorResult": "WAITING"},
+				{"status": "SUCCESS", "sessionToken": "token"},
+			},
+		},
+		{
+			name: "WAITING to REJECTED",
+			responses: []map[string]interface{}{
+				{"stateToken": "state", "status": "MFA_CHALLENGE", "factorResult": "WAITING"},
+				{"stateToken": "state", "status": "MFA_CHALLENGE", "factorResult": "REJECTED"},
+			},
+			want: oktadance.ErrPushRejected,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			polls := 0
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"stateToken": "state",
+					"status":     "MFA_REQUIRED",
+					"_embedded": map[string]interface{}{
+						"factors": []map[string]interface{}{
+							{"id": "push1", "factorType": "push", "provider": "OKTA"},
+						},
+					},
+				})
+			})
+			mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, tc.responses[polls])
+				polls++
+			})
+			d, srv := mockOkta(t, mux, oktadance.WithPollBackoff(oktadance.ConstantBackoff(time.Millisecond)))
+			defer srv.Close()
+
+			token, err := d.Authenticate(context.Background(), "user", "pass", nil)
+			assert.Equal(t, tc.want, err)
+			assert.Equal(t, len(tc.responses), polls)
+			if tc.want == nil {
+				assert.Equal(t, oktadance.SessionToken("token"), token)
+			}
+		})
+	}
+}
+
+func TestPushFactor_TerminalFactorResult(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "push1", "factorType": "push", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/push1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state",
+			"status":       "MFA_CHALLENGE",
+			"factorResult": "CANCELLED",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "CANCELLED")
+}
+
+func TestTOTPMultifactor(t *testing.T) {
+	// RFC 6238 test secret, "12345678901234567890"
+	mfa, err := oktadance.NewTOTPMultifactor("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	require.NoError(t, err)
+	mfa.Now = func() time.Time { return time.Unix(59, 0) }
+
+	code, err := mfa.ReadCode(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	_, err = oktadance.NewTOTPMultifactor("not base32!")
+	assert.Error(t, err)
+}
+
+func TestTOTPMultifactor_Skew(t *testing.T) {
+	mfa, err := oktadance.NewTOTPMultifactor("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	require.NoError(t, err)
+	now := time.Now()
+
+	// the device clock is a window behind Okta's
+	ahead, err := oktadance.NewTOTPMultifactor("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	require.NoError(t, err)
+	ahead.Now = func() time.Time { return now.Add(30 * time.Second) }
+	mfa.Now = func() time.Time { return now }
+	want, err := ahead.ReadCode(nil)
+	require.NoError(t, err)
+
+	var codes []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "totp1", "factorType": "token:software:totp", "provider": "GOOGLE"},
+					{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		codes = append(codes, body["passCode"])
+		if body["passCode"] != want {
+			writeJSON(w, http.StatusForbidden, map[string]interface{}{
+				"errorCode":    "E0000068",
+				"errorSummary": "Invalid Passcode/Answer",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Len(t, codes, 3, "current, previous, then next window")
+
+	mfa.Skew = 0
+	_, err = d.Authenticate(context.Background(), "user", "pass", mfa)
+	assert.True(t, errors.Is(err, oktadance.ErrTOTPWindowsExhausted), "unexpected error: %v", err)
+}
+
+func TestDance_AuthenticateWith_AuthMethods(t *testing.T) {
+	mfaRequired := true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		if !mfaRequired {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status":       "SUCCESS",
+				"sessionToken": "token",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []map[string]interface{}{
+					{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/sms1/verify", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	mfa := oktadance.MultifactorFunc{ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil }}
+	ar, err := d.AuthenticateWith(context.Background(), oktadance.AuthnRequest{
+		Username:    "user",
+		Password:    "pass",
+		Multifactor: mfa,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, ar.Factor)
+	assert.Equal(t, "sms1", ar.Factor.ID())
+	assert.Equal(t, []oktadance.AuthMethod{oktadance.AuthMethodPassword, oktadance.AuthMethodSMS, oktadance.AuthMethodMFA}, ar.AuthMethods)
+
+	mfaRequired = false
+	ar, err = d.AuthenticateWith(context.Background(), oktadance.AuthnRequest{
+		Username: "user",
+		Password: "pass",
+	})
+	require.NoError(t, err)
+	assert.Nil(t, ar.Factor)
+	assert.Equal(t, []oktadance.AuthMethod{oktadance.AuthMethodPassword}, ar.AuthMethods)
+}
@@ -0,0 +1,118 @@
+package oktadance
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTOTPSkew is how many time windows either side of the current
+// one a `TOTPMultifactor` tries, unless its Skew is changed
+const DefaultTOTPSkew = 1
+
+// totpPeriod is the lifetime of each TOTP code
+const totpPeriod = 30 * time.Second
+
+// ErrTOTPWindowsExhausted is returned when Okta has rejected the code
+// for every time window a `TOTPMultifactor` is allowed to try
+var ErrTOTPWindowsExhausted = errors.New("TOTP code rejected for every time window tried")
+
+// NewTOTPMultifactor creates a `Multifactor` which completes TOTP factors
+// (ie Google Authenticator or Okta Verify codes) itself, generating codes
+// from the factor's base32 encoded shared secret. This is suitable for
+// service accounts and tests which must log in unattended.
+func NewTOTPMultifactor(secret string) (*TOTPMultifactor, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return &TOTPMultifactor{key: key, Skew: DefaultTOTPSkew}, nil
+}
+
+// TOTPMultifactor generates TOTP codes from a shared secret. When Okta
+// rejects a code, ie due to clock skew, the codes of the adjacent time
+// windows are tried in turn. Note that each try counts as an attempt
+// towards `WithMaxCodeAttempts`, which must allow for `1 + 2*Skew` of them.
+type TOTPMultifactor struct {
+	// Skew is how many time windows either side of the current one to
+	// try, the default is `DefaultTOTPSkew`
+	Skew int
+
+	// Now gives the current time, time.Now if nil
+	Now func() time.Time
+
+	key []byte
+
+	mu       sync.Mutex
+	tries    int
+	retrying bool
+}
+
+// Select the first TOTP factor
+func (t *TOTPMultifactor) Select(factors []Factor) (Factor, error) {
+	f := SelectFactor(factors, FactorPreference{FactorType: "token:software:totp"})
+	if f == nil {
+		return nil, errors.New("no TOTP factor available")
+	}
+	return f, nil
+}
+
+// ReadCode generates the code for the current time window, or for the
+// next adjacent window when retrying after an incorrect code
+func (t *TOTPMultifactor) ReadCode(Factor) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.retrying {
+		t.tries = 0
+	}
+	t.retrying = false
+
+	if t.tries > 2*t.Skew {
+		return "", ErrTOTPWindowsExhausted
+	}
+	offset := windowOffset(t.tries)
+	t.tries++
+
+	now := time.Now
+	if t.Now != nil {
+		now = t.Now
+	}
+	return totpCode(t.key, now().Add(time.Duration(offset)*totpPeriod)), nil
+}
+
+// IncorrectCode notes that the next code should be for another window
+func (t *TOTPMultifactor) IncorrectCode(Factor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retrying = true
+}
+
+// windowOffset gives the window to try on each attempt: 0, -1, +1, -2, +2...
+func windowOffset(try int) int {
+	if try%2 == 1 {
+		return -(try + 1) / 2
+	}
+	return try / 2
+}
+
+// totpCode computes the six digit RFC 6238 code for the time
+func totpCode(key []byte, t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/int64(totpPeriod/time.Second)))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
@@ -0,0 +1,104 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// ClientAuthMethod is how a confidential client authenticates to the
+// OAuth endpoints, see `WithClientAuthMethod`
+type ClientAuthMethod string
+
+const (
+	// ClientSecretBasic sends the client secret via HTTP Basic auth
+	ClientSecretBasic ClientAuthMethod = "client_secret_basic"
+
+	// ClientSecretPost sends the client secret as a form parameter
+	ClientSecretPost ClientAuthMethod = "client_secret_post"
+)
+
+// WithClientSecret configures the client secret of a confidential client,
+// ie an App registered as a web app, which is sent to the OAuth endpoints
+// such as token and introspect. Without it the dance acts as a public
+// client.
+func WithClientSecret(secret string) Option {
+	return option(func(d *Dance) {
+		d.clientSecret = secret
+	})
+}
+
+// WithClientAuthMethod sets how the client secret is sent, the default
+// is `ClientSecretBasic`
+func WithClientAuthMethod(method ClientAuthMethod) Option {
+	return option(func(d *Dance) {
+		d.clientAuthMethod = method
+	})
+}
+
+// authenticateClient adds the client credentials to a request to an
+// OAuth endpoint, if the dance is a confidential client
+func (d *Dance) authenticateClient(req *http.Request, form url.Values) {
+	if d.clientSecret == "" {
+		return
+	}
+	switch d.clientAuthMethod {
+	case ClientSecretPost:
+		form.Set("client_id", d.clientID)
+		form.Set("client_secret", d.clientSecret)
+	default:
+		// RFC 6749 requires the credentials be form encoded first
+		req.SetBasicAuth(url.QueryEscape(d.clientID), url.QueryEscape(d.clientSecret))
+	}
+}
+
+// TokenIntrospection describes a token, as reported by the
+// introspect endpoint
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	TokenType string `json:"token_type"`
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss"`
+	UserID    string `json:"uid"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+}
+
+// IntrospectToken asks the authorization server whether a token is
+// active, and about whom it was issued to. The tokenTypeHint, ie
+// `access_token` or `refresh_token`, may be empty.
+//
+// This method requires a configured clientID, and for confidential
+// clients `WithClientSecret`.
+func (d *Dance) IntrospectToken(ctx context.Context, token, tokenTypeHint string) (*TokenIntrospection, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	u, err := d.endpoint(ctx, "introspect")
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("client_id", d.clientID)
+	form.Set("token", token)
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	body, err := d.postForm(ctx, "IntrospectToken", u, form)
+	if err != nil {
+		return nil, err
+	}
+
+	ti := &TokenIntrospection{}
+	err = json.Unmarshal(body, ti)
+	if err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
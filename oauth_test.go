@@ -0,0 +1,86 @@
+package oktadance_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_IntrospectToken_ClientSecret(t *testing.T) {
+	for _, tc := range []struct {
+		method oktadance.ClientAuthMethod
+	}{
+		{oktadance.ClientSecretBasic},
+		{oktadance.ClientSecretPost},
+	} {
+		t.Run(string(tc.method), func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/oauth2/v1/introspect", func(w http.ResponseWriter, r *http.Request) {
+				require.NoError(t, r.ParseForm())
+				id, secret, ok := r.BasicAuth()
+				if tc.method == oktadance.ClientSecretPost {
+					assert.False(t, ok)
+					id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+				} else {
+					// basic credentials are form encoded, per RFC 6749
+					secret, _ = url.QueryUnescape(secret)
+				}
+				assert.Equal(t, "client", id)
+				assert.Equal(t, "s3cr3t&", secret)
+				assert.Equal(t, "access-secret", r.PostForm.Get("token"))
+				assert.Equal(t, "access_token", r.PostForm.Get("token_type_hint"))
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"active":   true,
+					"username": "brian@example.com",
+					"sub":      "brian@example.com",
+					"exp":      1700000000,
+				})
+			})
+			var logs []string
+			d, srv := mockOkta(t, mux,
+				oktadance.WithClientID("client"),
+				oktadance.WithClientSecret("s3cr3t&"),
+				oktadance.WithClientAuthMethod(tc.method),
+				oktadance.WithLogger(func(args ...interface{}) {
+					logs = append(logs, fmt.Sprint(args...))
+				}),
+			)
+			defer srv.Close()
+
+			ti, err := d.IntrospectToken(context.Background(), "access-secret", "access_token")
+			require.NoError(t, err)
+			assert.True(t, ti.Active)
+			assert.Equal(t, "brian@example.com", ti.Username)
+			assert.Equal(t, int64(1700000000), ti.ExpiresAt)
+
+			all := strings.Join(logs, "\n")
+			assert.NotContains(t, all, "access-secret")
+			assert.NotContains(t, all, "s3cr3t")
+			assert.Contains(t, all, "token=REDACTED")
+		})
+	}
+}
+
+func TestDance_PublicClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/introspect", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		_, _, ok := r.BasicAuth()
+		assert.False(t, ok)
+		assert.Empty(t, r.PostForm.Get("client_secret"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	ti, err := d.IntrospectToken(context.Background(), "access", "")
+	require.NoError(t, err)
+	assert.False(t, ti.Active)
+}
@@ -0,0 +1,92 @@
+package oktadance
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// PreflightError lists the problems found by `Preflight` or `CheckConfig`
+type PreflightError struct {
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	return "oktadance misconfigured: " + strings.Join(e.Problems, "; ")
+}
+
+// CheckConfig checks the options for consistency, without making any
+// requests, ie at startup where Okta may not be reachable. Problems are
+// reported as a `*PreflightError`. See `Preflight` to also confirm the
+// domain exists.
+func (d *Dance) CheckConfig() error {
+	problems := d.configProblems()
+	if len(problems) > 0 {
+		return &PreflightError{Problems: problems}
+	}
+	return nil
+}
+
+// Preflight checks the dance is correctly configured before attempting
+// a login. The options are checked for consistency first, as by
+// `CheckConfig`, and only then is the OIDC metadata of the authorization
+// server fetched to confirm the domain, and authorization server, exist.
+// Problems are reported as a `*PreflightError`.
+func (d *Dance) Preflight(ctx context.Context) error {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	err := d.CheckConfig()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", d.discoveryURL(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.do("Preflight", req.WithContext(ctx))
+	if err != nil {
+		return &PreflightError{Problems: []string{fmt.Sprintf("Okta domain %s is not reachable: %v", d.oktaDomain, err)}}
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+
+	switch {
+	case res.StatusCode == http.StatusNotFound && d.authServer != "":
+		return &PreflightError{Problems: []string{fmt.Sprintf("authorization server %s not found on %s", d.authServer, d.oktaDomain)}}
+	case res.StatusCode >= 300:
+		return &PreflightError{Problems: []string{fmt.Sprintf("%s does not appear to be an Okta domain, status %d", d.oktaDomain, res.StatusCode)}}
+	}
+	return nil
+}
+
+// configProblems checks the options for consistency
+func (d *Dance) configProblems() []string {
+	problems := []string{}
+	switch {
+	case d.oktaDomain == "":
+		problems = append(problems, "no Okta domain given")
+	case strings.Contains(d.oktaDomain, "/"):
+		problems = append(problems, fmt.Sprintf("Okta domain should be a host name, ie example.okta.com, not %s", d.oktaDomain))
+	}
+	if d.err != nil {
+		problems = append(problems, d.err.Error())
+	}
+	if d.clientID == "" {
+		if d.verifyIDToken {
+			problems = append(problems, "WithVerifyIDToken requires WithClientID")
+		}
+		if d.authServer != "" {
+			problems = append(problems, "WithAuthorizationServer requires WithClientID")
+		}
+	}
+	if d.pollBackoff == nil {
+		problems = append(problems, "WithPollBackoff was given a nil BackoffFunc")
+	}
+	return problems
+}
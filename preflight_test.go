@@ -0,0 +1,64 @@
+package oktadance_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_Preflight(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/default/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"issuer": "https://example.okta.com/oauth2/default"})
+	})
+	mux.HandleFunc("/oauth2/missing/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errorCode": "E0000007"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	err := d.With(oktadance.WithAuthorizationServer("default")).Preflight(context.Background())
+	assert.NoError(t, err)
+
+	err = d.With(oktadance.WithAuthorizationServer("missing")).Preflight(context.Background())
+	pe := &oktadance.PreflightError{}
+	require.True(t, errors.As(err, &pe), "unexpected error: %v", err)
+	assert.Contains(t, pe.Problems[0], "authorization server missing not found")
+}
+
+func TestDance_Preflight_Config(t *testing.T) {
+	d := oktadance.New("https://example.okta.com/", oktadance.WithVerifyIDToken())
+
+	err := d.Preflight(context.Background())
+	pe := &oktadance.PreflightError{}
+	require.True(t, errors.As(err, &pe), "unexpected error: %v", err)
+	assert.Len(t, pe.Problems, 2)
+	assert.Contains(t, pe.Problems[0], "host name")
+	assert.Contains(t, pe.Problems[1], "WithClientID")
+
+	err = d.CheckConfig()
+	require.True(t, errors.As(err, &pe), "unexpected error: %v", err)
+	assert.Len(t, pe.Problems, 2)
+}
+
+func TestDance_CheckConfig(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errorCode": "E0000007"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithClientID("client"))
+	defer srv.Close()
+
+	assert.NoError(t, d.CheckConfig())
+	assert.Equal(t, 0, requests, "no requests should be made")
+
+	assert.Error(t, d.Preflight(context.Background()))
+	assert.Equal(t, 1, requests)
+}
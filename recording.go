@@ -0,0 +1,138 @@
+package oktadance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Exchange is a request to Okta and the response to it, as canned for or
+// recorded by a `RecordingTransport`. Only the method and path of a
+// request are used to match it to a canned response.
+type Exchange struct {
+	Method        string      `json:"method"`
+	Path          string      `json:"path"`
+	RequestHeader http.Header `json:"requestHeader,omitempty"`
+	RequestBody   string      `json:"requestBody,omitempty"`
+
+	// Status of the response, 200 if not given
+	Status         int             `json:"status,omitempty"`
+	ResponseHeader http.Header     `json:"responseHeader,omitempty"`
+	ResponseBody   json.RawMessage `json:"responseBody,omitempty"`
+}
+
+// LoadRecording reads canned exchanges from a JSON file holding an array
+// of `Exchange`, for use with `NewRecordingTransport`
+func LoadRecording(path string) ([]Exchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	exchanges := []Exchange{}
+	err = json.NewDecoder(f).Decode(&exchanges)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recording %s: %w", path, err)
+	}
+	return exchanges, nil
+}
+
+// RecordingTransport is an `http.RoundTripper` for tests which answers
+// requests with canned responses, rather than contacting Okta, and
+// records every request made so the exact payloads can be asserted.
+// Use it via `WithHTTPClient(rt.Client())`.
+type RecordingTransport struct {
+	mu       sync.Mutex
+	canned   []Exchange
+	used     []bool
+	recorded []Exchange
+}
+
+// NewRecordingTransport creates a transport answering with the canned
+// exchanges. Each is used once, in order, for the first request matching
+// its method and path; a request with no unused match fails.
+func NewRecordingTransport(canned ...Exchange) *RecordingTransport {
+	return &RecordingTransport{
+		canned: canned,
+		used:   make([]bool, len(canned)),
+	}
+}
+
+// Client returns an http client using the transport which, like the
+// dance's default client, does not follow redirects
+func (rt *RecordingTransport) Client() *http.Client {
+	return &http.Client{
+		Transport:     rt,
+		CheckRedirect: noRedirects,
+	}
+}
+
+// RoundTrip records the request and answers it with the matching canned
+// response
+func (rt *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := Exchange{
+		Method:        req.Method,
+		Path:          req.URL.Path,
+		RequestHeader: req.Header.Clone(),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		ex.RequestBody = string(body)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for i, c := range rt.canned {
+		if rt.used[i] || c.Method != ex.Method || c.Path != ex.Path {
+			continue
+		}
+		rt.used[i] = true
+
+		ex.Status = c.Status
+		if ex.Status == 0 {
+			ex.Status = http.StatusOK
+		}
+		ex.ResponseHeader = c.ResponseHeader.Clone()
+		if ex.ResponseHeader == nil {
+			ex.ResponseHeader = http.Header{}
+		}
+		if len(c.ResponseBody) > 0 && ex.ResponseHeader.Get("Content-Type") == "" {
+			ex.ResponseHeader.Set("Content-Type", "application/json")
+		}
+		ex.ResponseBody = c.ResponseBody
+		rt.recorded = append(rt.recorded, ex)
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+			StatusCode:    ex.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        ex.ResponseHeader.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(c.ResponseBody)),
+			ContentLength: int64(len(c.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+
+	rt.recorded = append(rt.recorded, ex)
+	return nil, fmt.Errorf("no recorded response for %s %s", ex.Method, ex.Path)
+}
+
+// Requests returns every exchange made so far, in order, including
+// requests which had no canned response
+func (rt *RecordingTransport) Requests() []Exchange {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]Exchange(nil), rt.recorded...)
+}
@@ -0,0 +1,46 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	canned, err := oktadance.LoadRecording("testdata/authn_totp.json")
+	require.NoError(t, err)
+
+	rt := oktadance.NewRecordingTransport(canned...)
+	d := oktadance.New("example.okta.com", oktadance.WithHTTPClient(rt.Client()))
+
+	mfa := oktadance.MultifactorFunc{
+		ReadCodeFunc: func(oktadance.Factor) (string, error) { return "123456", nil },
+	}
+	token, err := d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+
+	reqs := rt.Requests()
+	require.Len(t, reqs, 2)
+
+	assert.Equal(t, "/api/v1/authn", reqs[0].Path)
+	assert.Equal(t, "application/json", reqs[0].RequestHeader.Get("Content-Type"))
+	authn := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(reqs[0].RequestBody), &authn))
+	assert.Equal(t, "user", authn["username"])
+	assert.Equal(t, "pass", authn["password"])
+
+	assert.Equal(t, "/api/v1/authn/factors/totp1/verify", reqs[1].Path)
+	verify := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(reqs[1].RequestBody), &verify))
+	assert.Equal(t, map[string]interface{}{"stateToken": "state", "passCode": "123456"}, verify)
+
+	_, err = d.Authenticate(context.Background(), "user", "pass", mfa)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no recorded response for POST /api/v1/authn")
+	assert.Len(t, rt.Requests(), 3)
+}
@@ -0,0 +1,153 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrLockedOut matches (via `errors.Is`) the `*RecoveryError` returned
+// from `Authenticate` when the user's account is locked
+var ErrLockedOut = errors.New("account is locked out")
+
+// RecoveryError is returned from `Authenticate` when the user must
+// recover their account, ie via `Unlock` when locked out, or change
+// their password when it has expired or must be reset, before they can
+// log in
+type RecoveryError struct {
+	Status     AuthnStatus
+	StateToken string
+
+	// Links are the recovery endpoints Okta gave, by name, ie `unlock`
+	Links map[string]string
+}
+
+func (e *RecoveryError) Error() string {
+	return fmt.Sprintf("account recovery required, status %s", e.Status)
+}
+
+// Is reports whether the error is `ErrLockedOut`
+func (e *RecoveryError) Is(target error) bool {
+	return target == ErrLockedOut && e.Status == StatusLockedOut
+}
+
+// RecoveryResult is the state of a recovery transaction, such as one
+// started by `Unlock`
+type RecoveryResult struct {
+	// Status of the transaction, ie `StatusRecoveryChallenge` while a
+	// code or email link is awaited, `StatusRecovery` once it has been
+	// verified, and `StatusSuccess` when done
+	Status AuthnStatus
+
+	// StateToken to use for further steps of the transaction. It is
+	// empty while waiting on the user to follow an emailed link.
+	StateToken string
+
+	// RecoveryType is `UNLOCK` or `PASSWORD`
+	RecoveryType string
+
+	// FactorType is the recovery factor in use, ie `SMS` or `EMAIL`
+	FactorType string
+
+	// FactorResult is the outcome of verifying the factor, ie `WAITING`
+	FactorResult FactorResult
+
+	// Question is the user's recovery question, to be answered via
+	// `AnswerRecoveryQuestion` in the `RECOVERY` state
+	Question string
+
+	// SessionToken, once Status is `SUCCESS`, if Okta issued one
+	SessionToken SessionToken
+
+	// Links are the endpoints Okta gave for the next steps, by name
+	Links map[string]string
+}
+
+// Unlock starts self-service unlock of a locked out account, sending the
+// user a code via factorType `SMS` or `CALL`, to be given to
+// `VerifyRecoveryFactor`, or an unlock link via `EMAIL`. Okta reports
+// success even for unknown users, so as not to reveal which exist.
+//
+// prev is the `*RecoveryError` from `Authenticate`, whose `unlock` link
+// is followed. It may be nil to unlock without having tried to log in.
+func (d *Dance) Unlock(ctx context.Context, prev *RecoveryError, username, factorType string) (*RecoveryResult, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	var links map[string]string
+	if prev != nil {
+		links = prev.Links
+	}
+	return d.recoveryStep(ctx, "Unlock", links, "unlock", "/api/v1/authn/recovery/unlock", map[string]string{
+		"username":   username,
+		"factorType": factorType,
+	})
+}
+
+// VerifyRecoveryFactor submits the code sent to the user by `Unlock`
+func (d *Dance) VerifyRecoveryFactor(ctx context.Context, prev *RecoveryResult, passCode string) (*RecoveryResult, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	path := fmt.Sprintf("/api/v1/authn/recovery/factors/%s/verify", url.PathEscape(prev.FactorType))
+	return d.recoveryStep(ctx, "VerifyRecoveryFactor", prev.Links, "verify", path, map[string]string{
+		"stateToken": prev.StateToken,
+		"passCode":   passCode,
+	})
+}
+
+// AnswerRecoveryQuestion answers the user's recovery question, given in
+// the `RECOVERY` state, completing an unlock
+func (d *Dance) AnswerRecoveryQuestion(ctx context.Context, prev *RecoveryResult, answer string) (*RecoveryResult, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	return d.recoveryStep(ctx, "AnswerRecoveryQuestion", prev.Links, "answer", "/api/v1/authn/recovery/answer", map[string]string{
+		"stateToken": prev.StateToken,
+		"answer":     answer,
+	})
+}
+
+// ResetPassword sets a new password in the `PASSWORD_RESET` state of a
+// recovery transaction
+func (d *Dance) ResetPassword(ctx context.Context, prev *RecoveryResult, newPassword string) (*RecoveryResult, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	return d.recoveryStep(ctx, "ResetPassword", prev.Links, "resetPassword", "/api/v1/authn/credentials/reset_password", map[string]string{
+		"stateToken":  prev.StateToken,
+		"newPassword": newPassword,
+	})
+}
+
+// recoveryStep posts to the link of the given name from the previous
+// step, falling back to the documented path if Okta gave no such link
+func (d *Dance) recoveryStep(ctx context.Context, name string, links map[string]string, link, path string, payload map[string]string) (*RecoveryResult, error) {
+	u := fmt.Sprintf("https://%s%s", d.oktaDomain, path)
+	if links[link] != "" {
+		u = links[link]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	ar, err := d.authnStep(ctx, name, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecoveryResult{
+		Status:       ar.Status,
+		StateToken:   ar.StateToken,
+		RecoveryType: ar.RecoveryType,
+		FactorType:   ar.FactorType,
+		FactorResult: ar.FactorResult,
+		Question:     ar.Embedded.User.RecoveryQuestion.Question,
+		SessionToken: SessionToken(ar.SessionToken),
+		Links:        ar.Links.links(),
+	}, nil
+}
@@ -0,0 +1,155 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_Unlock(t *testing.T) {
+	var base string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "LOCKED_OUT",
+			"_links": map[string]interface{}{
+				"next": map[string]interface{}{"name": "unlock", "href": base + "/custom/unlock"},
+			},
+		})
+	})
+	unlocks := map[string]int{}
+	unlock := func(w http.ResponseWriter, r *http.Request) {
+		unlocks[r.URL.Path]++
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, map[string]string{"username": "user", "factorType": "SMS"}, body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state1",
+			"status":       "RECOVERY_CHALLENGE",
+			"recoveryType": "UNLOCK",
+			"factorType":   "SMS",
+		})
+	}
+	mux.HandleFunc("/custom/unlock", unlock)
+	mux.HandleFunc("/api/v1/authn/recovery/unlock", unlock)
+	mux.HandleFunc("/api/v1/authn/recovery/factors/SMS/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, map[string]string{"stateToken": "state1", "passCode": "123456"}, body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken":   "state2",
+			"status":       "RECOVERY",
+			"recoveryType": "UNLOCK",
+			"_embedded": map[string]interface{}{
+				"user": map[string]interface{}{
+					"recovery_question": map[string]interface{}{"question": "Who's a major player in the cowboy scene?"},
+				},
+			},
+			"_links": map[string]interface{}{
+				"next": map[string]interface{}{"name": "answer", "href": base + "/custom/answer"},
+			},
+		})
+	})
+	mux.HandleFunc("/custom/answer", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, map[string]string{"stateToken": "state2", "answer": "Annie Oakley"}, body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"recoveryType": "UNLOCK",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+	base = srv.URL
+
+	ctx := context.Background()
+	_, err := d.Authenticate(ctx, "user", "pass", nil)
+	assert.True(t, errors.Is(err, oktadance.ErrLockedOut), "unexpected error: %v", err)
+	re := &oktadance.RecoveryError{}
+	require.True(t, errors.As(err, &re))
+	assert.Equal(t, base+"/custom/unlock", re.Links["unlock"])
+
+	rr, err := d.Unlock(ctx, nil, "user", "SMS")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusRecoveryChallenge, rr.Status)
+	assert.Equal(t, map[string]int{"/api/v1/authn/recovery/unlock": 1}, unlocks, "without a RecoveryError the documented path is used")
+
+	rr, err = d.Unlock(ctx, re, "user", "SMS")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusRecoveryChallenge, rr.Status)
+	assert.Equal(t, 1, unlocks["/custom/unlock"], "the unlock link should be followed")
+
+	rr, err = d.VerifyRecoveryFactor(ctx, rr, "123456")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusRecovery, rr.Status)
+	assert.Equal(t, "Who's a major player in the cowboy scene?", rr.Question)
+
+	rr, err = d.AnswerRecoveryQuestion(ctx, rr, "Annie Oakley")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusSuccess, rr.Status)
+}
+
+func TestDance_ResetPassword(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn/credentials/reset_password", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, map[string]string{"stateToken": "state1", "newPassword": "new-secret"}, body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+
+	var logs []string
+	d, srv := mockOkta(t, mux, oktadance.WithLogger(func(args ...interface{}) {
+		logs = append(logs, fmt.Sprint(args...))
+	}))
+	defer srv.Close()
+
+	prev := &oktadance.RecoveryResult{Status: oktadance.StatusPasswordReset, StateToken: "state1"}
+	rr, err := d.ResetPassword(context.Background(), prev, "new-secret")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.StatusSuccess, rr.Status)
+	assert.Equal(t, oktadance.SessionToken("token"), rr.SessionToken)
+
+	all := strings.Join(logs, "\n")
+	assert.NotContains(t, all, "new-secret")
+	assert.Contains(t, all, `"newPassword":"REDACTED"`)
+}
+
+func TestDance_Authenticate_PasswordRecovery(t *testing.T) {
+	for _, status := range []oktadance.AuthnStatus{oktadance.StatusPasswordExpired, oktadance.StatusPasswordReset} {
+		t.Run(string(status), func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"stateToken": "state1",
+					"status":     status,
+					"_links": map[string]interface{}{
+						"next": map[string]interface{}{"name": "changePassword", "href": "https://example.okta.com/api/v1/authn/credentials/change_password"},
+					},
+				})
+			})
+			d, srv := mockOkta(t, mux)
+			defer srv.Close()
+
+			_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+			re := &oktadance.RecoveryError{}
+			require.True(t, errors.As(err, &re), "unexpected error: %v", err)
+			assert.Equal(t, status, re.Status)
+			assert.Equal(t, "state1", re.StateToken)
+			assert.Equal(t, "https://example.okta.com/api/v1/authn/credentials/change_password", re.Links["changePassword"])
+			assert.False(t, errors.Is(err, oktadance.ErrLockedOut))
+		})
+	}
+}
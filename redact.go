@@ -0,0 +1,84 @@
+package oktadance
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces secret values in logs
+const redacted = "REDACTED"
+
+// redactHeaders returns a copy of the headers with the values of
+// those which carry credentials (sids, API tokens) masked
+func redactHeaders(h http.Header) http.Header {
+	rh := http.Header{}
+	for k, vs := range h {
+		switch k {
+		case "Authorization":
+			for _, v := range vs {
+				rh.Add(k, redactAuthorization(v))
+			}
+		case "Cookie":
+			for _, v := range vs {
+				rh.Add(k, redactCookies(v))
+			}
+		case "Set-Cookie":
+			for _, v := range vs {
+				rh.Add(k, redactSetCookie(v))
+			}
+		default:
+			rh[k] = vs
+		}
+	}
+	return rh
+}
+
+// redactAuthorization masks the credentials, keeping the scheme (ie `SSWS`)
+func redactAuthorization(v string) string {
+	if i := strings.Index(v, " "); i > 0 {
+		return v[:i+1] + redacted
+	}
+	return redacted
+}
+
+// redactCookies masks the value of each cookie in a Cookie header,
+// keeping the names
+func redactCookies(v string) string {
+	parts := strings.Split(v, ";")
+	for i, p := range parts {
+		parts[i] = redactCookie(p)
+	}
+	return strings.Join(parts, ";")
+}
+
+// redactSetCookie masks the value of a Set-Cookie header, keeping
+// the name and attributes
+func redactSetCookie(v string) string {
+	parts := strings.SplitN(v, ";", 2)
+	parts[0] = redactCookie(parts[0])
+	return strings.Join(parts, ";")
+}
+
+// redactCookie masks a single `name=value` pair
+func redactCookie(pair string) string {
+	if i := strings.Index(pair, "="); i >= 0 {
+		return pair[:i+1] + redacted
+	}
+	return pair
+}
+
+// secretFields are the names of fields which carry credentials
+const secretFields = `sessionToken|stateToken|token|password|newPassword|passCode|answer|access_token|id_token|refresh_token|device_code|client_secret|code`
+
+var (
+	secretJSONField  = regexp.MustCompile(`("(?:` + secretFields + `)"\s*:\s*)"[^"]*"`)
+	secretQueryField = regexp.MustCompile(`\b(` + secretFields + `)=[^&\s"'<>]*`)
+)
+
+// sanitizeBody masks the values of secret fields in a body, as JSON or as
+// url parameters, so it may be included in an error or logged
+func sanitizeBody(body []byte) string {
+	s := secretJSONField.ReplaceAllString(string(body), `${1}"`+redacted+`"`)
+	return secretQueryField.ReplaceAllString(s, "${1}="+redacted)
+}
@@ -0,0 +1,56 @@
+package oktadance
+
+import (
+	"net/http"
+	"sync"
+)
+
+// WithRedirectHandler replaces the default policy of never following
+// redirects, for tenants whose authorize flow legitimately chains
+// redirects, ie through a custom authorization server. The handler has
+// the signature of `http.Client.CheckRedirect`: return nil to follow the
+// redirect, or `http.ErrUseLastResponse` to stop and hand the response to
+// `Authorize`, which needs the final redirect to Okta's callback. Cookies
+// set by the redirects which were followed are still seen by `Authorize`.
+// It may not be combined with `WithHTTPClient`.
+func WithRedirectHandler(handler func(req *http.Request, via []*http.Request) error) Option {
+	return clientOption(func(d *Dance) {
+		d.redirectHandler = handler
+	})
+}
+
+// noRedirects is the default redirect policy
+func noRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// checkRedirect wraps a redirect handler to collect the cookies set by
+// each redirect it follows
+func checkRedirect(handler func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if rc, ok := req.Context().Value(redirectCookiesKey{}).(*redirectCookies); ok && req.Response != nil {
+			rc.add(req.Response.Cookies())
+		}
+		return handler(req, via)
+	}
+}
+
+type redirectCookiesKey struct{}
+
+// redirectCookies collects the cookies set by followed redirects
+type redirectCookies struct {
+	mu      sync.Mutex
+	cookies []*http.Cookie
+}
+
+func (rc *redirectCookies) add(cookies []*http.Cookie) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.cookies = append(rc.cookies, cookies...)
+}
+
+func (rc *redirectCookies) all() []*http.Cookie {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]*http.Cookie(nil), rc.cookies...)
+}
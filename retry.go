@@ -0,0 +1,127 @@
+package oktadance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether a request should be retried, given the
+// name of the operation, ie `Authenticate`, and the response or error
+// from the attempt. The response body must not be read.
+type RetryPolicy func(op string, res *http.Response, err error) bool
+
+// DefaultRetryPolicy retries requests Okta cannot have acted on: those
+// which failed to connect, and those rejected by rate limiting (429) or
+// as unavailable (503). The gateway errors (502, 504) seen while Okta is
+// under load are retried only for GET requests, as Okta may have acted
+// on anything else. Other network errors are not retried, as the request
+// may have been received; resending a password could count towards
+// lockout, and resending a one time passCode fails as it is already used.
+func DefaultRetryPolicy(op string, res *http.Response, err error) bool {
+	if err != nil {
+		return notSent(err)
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return res.Request != nil && res.Request.Method == "GET"
+	}
+	return false
+}
+
+// notSent reports whether the error shows the request was never sent,
+// as the connection to Okta could not be made
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryAttempts is how many times a request is attempted when retrying
+const retryAttempts = 3
+
+// retryBackoff is how long to wait between attempts, unless Okta says
+// otherwise via Retry-After
+var retryBackoff = ExponentialBackoff(250*time.Millisecond, 4*time.Second)
+
+// WithRetryPolicy retries failed requests, up to three attempts in all,
+// when the policy says they should be. `DefaultRetryPolicy` suits most
+// callers. Without this option requests are not retried.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return option(func(d *Dance) {
+		d.retryPolicy = policy
+	})
+}
+
+// send sends the request, retrying as allowed by the retry policy
+func (d *Dance) send(name string, req *http.Request) (*http.Response, error) {
+	if d.retryPolicy == nil {
+		return d.roundTrip(req)
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		req.Body, _ = req.GetBody()
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := d.roundTrip(req)
+		if attempt+1 >= retryAttempts || req.Context().Err() != nil || !d.retryPolicy(name, res, err) {
+			return res, err
+		}
+
+		wait := retryBackoff(attempt)
+		reason := fmt.Sprint(err)
+		if res != nil {
+			if after, ok := retryAfter(res); ok {
+				wait = after
+			}
+			reason = res.Status
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		if d.logger != nil {
+			d.logger.Warn(name, fmt.Sprintf("retrying in %s after %s", wait, reason))
+		}
+
+		select {
+		case <-d.after(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryAfter gives the wait asked for by a Retry-After header in seconds
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	secs, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
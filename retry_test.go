@@ -0,0 +1,175 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_RetryPolicy(t *testing.T) {
+	attempts := 0
+	var usernames []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		usernames = append(usernames, body["username"])
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{"errorCode": "E0000047"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithRetryPolicy(oktadance.DefaultRetryPolicy))
+	defer srv.Close()
+
+	token, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionToken("token"), token)
+	assert.Equal(t, []string{"user", "user"}, usernames, "the body should be resent")
+
+	// without a policy the 429 is returned
+	attempts = 0
+	_, err = d.With(oktadance.WithRetryPolicy(nil)).Authenticate(context.Background(), "user", "pass", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// the policy decides
+	attempts = 0
+	var ops []string
+	_, err = d.With(oktadance.WithRetryPolicy(func(op string, res *http.Response, err error) bool {
+		ops = append(ops, op)
+		return false
+	})).Authenticate(context.Background(), "user", "pass", nil)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Authenticate"}, ops)
+}
+
+func TestDefaultRetryPolicy(t *testing.T) {
+	var attempts int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(200 * time.Millisecond)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/totp1/verify", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		writeJSON(w, http.StatusGatewayTimeout, map[string]interface{}{})
+	})
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		writeJSON(w, http.StatusGatewayTimeout, map[string]interface{}{})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+	clock := &fakeClock{}
+	d := oktadance.New(host,
+		oktadance.WithInsecureSkipVerify(),
+		oktadance.WithResponseHeaderTimeout(20*time.Millisecond),
+		oktadance.WithRetryPolicy(oktadance.DefaultRetryPolicy),
+		oktadance.WithClock(clock),
+	)
+	defer d.Close()
+
+	// the password was sent, so is not sent again
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	// Okta may have used the passCode
+	atomic.StoreInt32(&attempts, 0)
+	_, err = d.VerifyFactor(context.Background(), "state", "totp1", "123456")
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	// a GET is safe to retry
+	atomic.StoreInt32(&attempts, 0)
+	_, err = d.Session(context.Background(), "sid")
+	require.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// nothing was sent when the connection fails
+	dials := 0
+	closed := httptest.NewTLSServer(mux)
+	closed.Close()
+	_, err = d.With(
+		oktadance.WithDomain(strings.TrimPrefix(closed.URL, "https://")),
+		oktadance.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				dials++
+				return next.RoundTrip(req)
+			})
+		}),
+	).Authenticate(context.Background(), "user", "pass", nil)
+	require.Error(t, err)
+	assert.Equal(t, 3, dials)
+}
+
+// levelLogger records the level of each line logged
+type levelLogger struct {
+	mu    sync.Mutex
+	lines map[string][]string
+}
+
+func (l *levelLogger) log(level string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lines == nil {
+		l.lines = map[string][]string{}
+	}
+	l.lines[level] = append(l.lines[level], fmt.Sprint(args...))
+}
+
+func (l *levelLogger) Debug(args ...interface{}) { l.log("debug", args...) }
+func (l *levelLogger) Info(args ...interface{})  { l.log("info", args...) }
+func (l *levelLogger) Warn(args ...interface{})  { l.log("warn", args...) }
+func (l *levelLogger) Error(args ...interface{}) { l.log("error", args...) }
+
+func TestDance_LeveledLogger(t *testing.T) {
+	attempts := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{"errorCode": "E0000047"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": "token",
+		})
+	})
+	logger := &levelLogger{}
+	d, srv := mockOkta(t, mux, oktadance.WithRetryPolicy(oktadance.DefaultRetryPolicy), oktadance.WithLeveledLogger(logger))
+	defer srv.Close()
+
+	_, err := d.Authenticate(context.Background(), "user", "pass", nil)
+	require.NoError(t, err)
+
+	require.Len(t, logger.lines["warn"], 1)
+	assert.Contains(t, logger.lines["warn"][0], "retrying in 0s after 429 Too Many Requests")
+	assert.Len(t, logger.lines["debug"], 2, "the request and final response are dumped")
+	assert.Empty(t, logger.lines["error"])
+}
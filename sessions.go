@@ -0,0 +1,514 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// sessionsValidConcurrency bounds the requests made at once by `SessionsValid`
+const sessionsValidConcurrency = 8
+
+// SessionsValid checks each of the sessions, reporting whether it is
+// active. Sessions which Okta does not know, ie they have expired or been
+// closed, are reported as not valid. Any other error, such as a network
+// or authorization failure, stops the checks and is returned.
+func (d *Dance) SessionsValid(ctx context.Context, sessionIDs ...SessionID) (map[SessionID]bool, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		valid    = make(map[SessionID]bool, len(sessionIDs))
+		sem      = make(chan struct{}, sessionsValidConcurrency)
+	)
+	for _, sid := range sessionIDs {
+		if !sid.Valid() {
+			mu.Lock()
+			valid[sid] = false
+			mu.Unlock()
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(sid SessionID) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ok, err := d.sessionValid(ctx, sid)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					stop()
+				}
+				return
+			}
+			valid[sid] = ok
+		}(sid)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return valid, nil
+}
+
+// sessionValid reports whether a single session is active
+func (d *Dance) sessionValid(ctx context.Context, sessionID SessionID) (bool, error) {
+	sess, err := d.Session(ctx, sessionID)
+	if errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionInactive) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sess.Status == SessionActive, nil
+}
+
+// ReverifyPassword confirms the password of the user who owns the session,
+// ie to "confirm your password to continue" before a sensitive operation.
+// The password is checked by a fresh primary authentication for the
+// session's login. A wrong password gives false with no error.
+//
+// When Okta accepts the password outright, the sessionToken it issues is
+// redeemed along with the session's cookie, so Okta records the
+// verification against the session, updating its
+// `LastPasswordVerification`, and no token is left outstanding. When
+// Okta would go on to ask for MFA, or for a password change, the
+// transaction is cancelled instead: the password is reported as correct,
+// but as the user did not complete the login, the session is unchanged.
+func (d *Dance) ReverifyPassword(ctx context.Context, sessionID SessionID, password string) (bool, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	sess, err := d.Session(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	body, err := json.Marshal(oktaAuthnRequest{
+		Username: sess.Login,
+		Password: password,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	ar, err := d.authnStep(ctx, "ReverifyPassword", fmt.Sprintf("https://%s/api/v1/authn", d.oktaDomain), body)
+	oe := &OktaError{}
+	if errors.As(err, &oe) && oe.StatusCode == http.StatusUnauthorized {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch ar.Status {
+	case StatusSuccess:
+		err = d.stepUpSession(ctx, sessionID, SessionToken(ar.SessionToken))
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	case StatusMFARequired, StatusMFAEnroll, StatusPasswordWarn, StatusPasswordExpired:
+		// the password was accepted, only later steps remain
+		err = d.CancelAuthn(ctx, ar.StateToken)
+		if err != nil && d.logger != nil {
+			d.logger.Error("ReverifyPassword", "failed to cancel authn transaction:", err)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unexpected status: %s", ar.Status)
+}
+
+// stepUpSession redeems a sessionToken for the user who owns the session,
+// presenting the session's cookie so Okta records the authentication
+// against it. Should Okta start a new session instead, the new one is
+// closed so as not to leave it behind.
+func (d *Dance) stepUpSession(ctx context.Context, sessionID SessionID, token SessionToken) error {
+	sid, err := d.sessionCookieRedirect(ctx, "ReverifyPassword", token, fmt.Sprintf("https://%s/", d.oktaDomain), sessionID)
+	if err != nil {
+		return err
+	}
+	if sid == sessionID {
+		return nil
+	}
+
+	err = d.CloseSession(ctx, sid)
+	if err != nil && d.logger != nil {
+		d.logger.Error("ReverifyPassword", "failed to close new session:", err)
+	}
+	return nil
+}
+
+// EstablishSession exchanges a sessionToken, from `Authenticate`, for a
+// session via Okta's session cookie redirect, returning the sid Okta sets.
+// Unlike `Authorize` this needs no clientID. Okta only redirects to a
+// redirectURL which is a trusted origin of the org, though the redirect
+// itself is not followed. If Okta does not set the sid, ie because the
+// redirectURL is not trusted, the error is `ErrNoSessionCookie`.
+func (d *Dance) EstablishSession(ctx context.Context, sessionToken SessionToken, redirectURL string) (SessionID, error) {
+	if !sessionToken.Valid() {
+		return "", ErrEmptySessionToken
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	return d.sessionCookieRedirect(ctx, "EstablishSession", sessionToken, redirectURL, "")
+}
+
+// sessionCookieRedirect redeems a sessionToken via Okta's session cookie
+// redirect, presenting the existing session's cookie, if any, and
+// returning the sid Okta sets
+func (d *Dance) sessionCookieRedirect(ctx context.Context, name string, sessionToken SessionToken, redirectURL string, sessionID SessionID) (SessionID, error) {
+	q := url.Values{}
+	q.Set("token", string(sessionToken))
+	q.Set("redirectUrl", redirectURL)
+	u := fmt.Sprintf("https://%s/login/sessionCookieRedirect?%s", d.oktaDomain, q.Encode())
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	if sessionID.Valid() {
+		req.AddCookie(sessionID.Cookie())
+	}
+
+	rc := &redirectCookies{}
+	res, err := d.do(name, req.WithContext(context.WithValue(ctx, redirectCookiesKey{}, rc)))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		buf, _ := ioutil.ReadAll(res.Body)
+		err = oktaError(res, buf)
+		var oe *OktaError
+		if errors.As(err, &oe) && oe.ErrorCode == invalidTokenCode {
+			return "", fmt.Errorf("%w: %s", ErrSessionTokenConsumed, oe.ErrorSummary)
+		}
+		return "", err
+	}
+
+	var sid SessionID
+	for _, c := range append(rc.all(), res.Cookies()...) {
+		if c.Name == sessionCookieName {
+			sid = SessionIDFromCookie(c)
+		}
+	}
+	if !sid.Valid() && sessionID.Valid() {
+		// Okta kept the existing session
+		return sessionID, nil
+	}
+	if !sid.Valid() {
+		return "", ErrNoSessionCookie
+	}
+	return sid, nil
+}
+
+// MySessions lists the active sessions of the user who owns the session,
+// ie for a "where am I logged in" screen. Like `Session`, it only needs
+// the sessionId, not an API token.
+func (d *Dance) MySessions(ctx context.Context, sessionID SessionID) ([]Session, error) {
+	if !sessionID.Valid() {
+		return nil, ErrEmptySessionID
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	body, err := d.sessionAPI(ctx, "MySessions", "GET", "/api/v1/users/me/sessions", sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	err = json.Unmarshal(body, &sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// AuthMethod is an authentication method, as reported in the `amr` of a
+// session or id_token, per RFC 8176
+type AuthMethod string
+
+const (
+	// AuthMethodPassword is a password, `pwd`
+	AuthMethodPassword AuthMethod = "pwd"
+
+	// AuthMethodMFA reports that multiple factors were used, `mfa`
+	AuthMethodMFA AuthMethod = "mfa"
+
+	// AuthMethodOTP is a one time password, ie from an authenticator app
+	// or hardware token, `otp`
+	AuthMethodOTP AuthMethod = "otp"
+
+	// AuthMethodHardwareKey is a proof of possession of a hardware
+	// secured key, ie a U2F or WebAuthn security key, `hwk`
+	AuthMethodHardwareKey AuthMethod = "hwk"
+
+	// AuthMethodSoftwareKey is a proof of possession of a software
+	// secured key, ie Okta Verify push, `swk`
+	AuthMethodSoftwareKey AuthMethod = "swk"
+
+	// AuthMethodSMS is a code sent by SMS, `sms`
+	AuthMethodSMS AuthMethod = "sms"
+
+	// AuthMethodTelephone is a code given by a voice call, `tel`
+	AuthMethodTelephone AuthMethod = "tel"
+
+	// AuthMethodEmail is a code sent by email, `email`. This is Okta's,
+	// it is not registered by RFC 8176.
+	AuthMethodEmail AuthMethod = "email"
+
+	// AuthMethodKnowledge is knowledge based, ie a security question, `kba`
+	AuthMethodKnowledge AuthMethod = "kba"
+
+	// AuthMethodSmartCard is a smart card, `sc`
+	AuthMethodSmartCard AuthMethod = "sc"
+
+	// AuthMethodProofOfPossession is a proof of possession of a key,
+	// `pop`
+	AuthMethodProofOfPossession AuthMethod = "pop"
+
+	// AuthMethodFingerprint is a fingerprint, `fpt`
+	AuthMethodFingerprint AuthMethod = "fpt"
+
+	// AuthMethodFace is facial recognition, `face`
+	AuthMethodFace AuthMethod = "face"
+
+	// AuthMethodPIN is a PIN, `pin`
+	AuthMethodPIN AuthMethod = "pin"
+
+	// AuthMethodUnknown is any `amr` value not listed above
+	AuthMethodUnknown AuthMethod = "unknown"
+)
+
+// knownAuthMethods are the `amr` values with an `AuthMethod` constant
+var knownAuthMethods = map[AuthMethod]bool{
+	AuthMethodPassword:          true,
+	AuthMethodMFA:               true,
+	AuthMethodOTP:               true,
+	AuthMethodHardwareKey:       true,
+	AuthMethodSoftwareKey:       true,
+	AuthMethodSMS:               true,
+	AuthMethodTelephone:         true,
+	AuthMethodEmail:             true,
+	AuthMethodKnowledge:         true,
+	AuthMethodSmartCard:         true,
+	AuthMethodProofOfPossession: true,
+	AuthMethodFingerprint:       true,
+	AuthMethodFace:              true,
+	AuthMethodPIN:               true,
+}
+
+// ParseAuthMethod gives the `AuthMethod` for an `amr` value, or
+// `AuthMethodUnknown` if it is not one listed
+func ParseAuthMethod(amr string) AuthMethod {
+	if m := AuthMethod(amr); knownAuthMethods[m] {
+		return m
+	}
+	return AuthMethodUnknown
+}
+
+// possessionMethods are the methods for factors the user proves they
+// possess, per RFC 8176
+var possessionMethods = map[AuthMethod]bool{
+	AuthMethodHardwareKey:       true,
+	AuthMethodSoftwareKey:       true,
+	AuthMethodSmartCard:         true,
+	AuthMethodOTP:               true,
+	AuthMethodSMS:               true,
+	AuthMethodTelephone:         true,
+	AuthMethodProofOfPossession: true,
+}
+
+// AuthMethods gives the methods used to authenticate the session, ie
+// `AuthMethodPassword` and `AuthMethodMFA`, as parsed by `ParseAuthMethod`,
+// so values Okta reports which are not known here are `AuthMethodUnknown`.
+// The raw values are in `Amr`.
+func (s *Session) AuthMethods() []AuthMethod {
+	methods := make([]AuthMethod, 0, len(s.Amr))
+	for _, m := range s.Amr {
+		methods = append(methods, ParseAuthMethod(m))
+	}
+	return methods
+}
+
+// HasMFA reports whether more than one factor was used to authenticate
+// the session. Note this differs from `MfaActive`, which reports whether
+// the user has MFA enrolled.
+func (s *Session) HasMFA() bool {
+	methods := map[string]bool{}
+	for _, m := range s.Amr {
+		if AuthMethod(m) == AuthMethodMFA {
+			return true
+		}
+		methods[m] = true
+	}
+	return len(methods) > 1
+}
+
+// SatisfiesAAL2 reports whether the session was authenticated with
+// multiple factors, at least one of which is a possession factor such as
+// a one time password or security key, as NIST SP 800-63B requires for
+// authenticator assurance level 2.
+func (s *Session) SatisfiesAAL2() bool {
+	if !s.HasMFA() {
+		return false
+	}
+	for _, m := range s.AuthMethods() {
+		if possessionMethods[m] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the session has expired as of the given
+// time. See `Dance.IsSessionExpired` to check against the dance's clock.
+func (s *Session) IsExpired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// IsSessionExpired reports whether the session has expired as of now, by
+// the clock given to `WithNowFunc` or `WithClock`
+func (d *Dance) IsSessionExpired(s *Session) bool {
+	return s.IsExpired(d.now())
+}
+
+// SessionEventType identifies what a `SessionEvent` reports
+type SessionEventType string
+
+const (
+	// SessionChanged reports that the session's status or expiry changed,
+	// ie it was refreshed elsewhere
+	SessionChanged SessionEventType = "CHANGED"
+
+	// SessionExpired reports that the session ended by reaching its expiry
+	SessionExpired SessionEventType = "EXPIRED"
+
+	// SessionRevoked reports that the session ended before its expiry,
+	// ie the user logged out or an admin cleared their sessions
+	SessionRevoked SessionEventType = "REVOKED"
+
+	// SessionCheckFailed reports that the session could not be checked,
+	// ie due to a network error. Watching continues.
+	SessionCheckFailed SessionEventType = "CHECK_FAILED"
+)
+
+// SessionEvent is a change observed by `WatchSession`
+type SessionEvent struct {
+	Type SessionEventType
+
+	// Session is the latest state of the session, nil once it has ended
+	Session *Session
+
+	// Err is the failure, for `SessionCheckFailed`
+	Err error
+}
+
+// DefaultSessionWatchInterval is how often `WatchSession` checks the
+// session unless overridden via `WithSessionWatchInterval`
+const DefaultSessionWatchInterval = time.Minute
+
+// WithSessionWatchInterval sets how often `WatchSession` checks the
+// session. An interval which is not positive gives the default,
+// `DefaultSessionWatchInterval`.
+func WithSessionWatchInterval(interval time.Duration) Option {
+	return option(func(d *Dance) {
+		if interval <= 0 {
+			interval = DefaultSessionWatchInterval
+		}
+		d.watchInterval = interval
+	})
+}
+
+// WatchSession checks the session periodically, sending an event when it
+// changes, expires, or is revoked. The channel is closed once the session
+// has ended or the context is done. An error is returned if the session
+// cannot be checked initially.
+func (d *Dance) WatchSession(ctx context.Context, sessionID SessionID) (<-chan SessionEvent, error) {
+	d = d.withCallOptions(ctx)
+	last, err := d.Session(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan SessionEvent)
+	go func() {
+		defer close(events)
+
+		send := func(e SessionEvent) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case <-d.after(d.watchInterval):
+			case <-ctx.Done():
+				return
+			}
+
+			sess, err := d.Session(ctx, sessionID)
+			switch {
+			case errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionInactive):
+				if !d.IsSessionExpired(last) {
+					send(SessionEvent{Type: SessionRevoked})
+				} else {
+					send(SessionEvent{Type: SessionExpired})
+				}
+				return
+			case err != nil:
+				if ctx.Err() != nil {
+					return
+				}
+				if !send(SessionEvent{Type: SessionCheckFailed, Err: err}) {
+					return
+				}
+			case sess.Status != last.Status || !sess.ExpiresAt.Equal(last.ExpiresAt):
+				last = sess
+				if !send(SessionEvent{Type: SessionChanged, Session: sess}) {
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,339 @@
+package oktadance_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_SessionsValid(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("sid")
+		require.NoError(t, err)
+		switch c.Value {
+		case "active":
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": "active", "status": "ACTIVE"})
+		case "mfa":
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": "mfa", "status": "MFA_REQUIRED"})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{
+				"errorCode":    "E0000007",
+				"errorSummary": "Not found: Resource not found: me (Session)",
+			})
+		}
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	valid, err := d.SessionsValid(context.Background(), "active", "mfa", "gone", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[oktadance.SessionID]bool{
+		"active": true,
+		"mfa":    false,
+		"gone":   false,
+		"":       false,
+	}, valid)
+}
+
+func TestDance_SessionsValid_Error(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"errorCode":    "E0000006",
+			"errorSummary": "You do not have permission to perform the requested action",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	_, err := d.SessionsValid(context.Background(), "a", "b", "c")
+	oe := &oktadance.OktaError{}
+	require.True(t, errors.As(err, &oe), "unexpected error: %v", err)
+	assert.Equal(t, http.StatusForbidden, oe.StatusCode)
+}
+
+func TestDance_MaxConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithMaxConcurrentRequests(2))
+	defer srv.Close()
+
+	valid, err := d.SessionsValid(context.Background(), "a", "b", "c", "d", "e", "f")
+	require.NoError(t, err)
+	assert.Len(t, valid, 6)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, peak)
+}
+
+func TestDance_ReverifyPassword(t *testing.T) {
+	cancelled := 0
+	var redeemed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "login": "user@example.com", "status": "ACTIVE"})
+	})
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "user@example.com", body["username"])
+		if body["password"] != "secret" {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"errorCode":    "E0000004",
+				"errorSummary": "Authentication failed",
+			})
+			return
+		}
+		if r.Header.Get("X-MFA") != "" {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"stateToken": "state", "status": "MFA_REQUIRED"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "SUCCESS", "sessionToken": "token"})
+	})
+	mux.HandleFunc("/login/sessionCookieRedirect", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("sid")
+		require.NoError(t, err)
+		redeemed = append(redeemed, r.URL.Query().Get("token")+" "+c.Value)
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: c.Value, Path: "/"})
+		w.Header().Set("Location", r.URL.Query().Get("redirectUrl"))
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/api/v1/authn/cancel", func(w http.ResponseWriter, r *http.Request) {
+		cancelled++
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"errorCode": "E0000009"})
+	})
+	logger := &levelLogger{}
+	d, srv := mockOkta(t, mux, oktadance.WithLeveledLogger(logger))
+	defer srv.Close()
+
+	ok, err := d.ReverifyPassword(context.Background(), "sid", "secret")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"token sid"}, redeemed, "the sessionToken should be redeemed with the session")
+	assert.Equal(t, 0, cancelled)
+
+	mfa := d.With(oktadance.WithDefaultHeaders(http.Header{"X-Mfa": {"1"}}))
+	ok, err = mfa.ReverifyPassword(context.Background(), "sid", "secret")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, cancelled)
+	require.Len(t, logger.lines["error"], 1, "the failure to cancel should be logged")
+	assert.Contains(t, logger.lines["error"][0], "failed to cancel authn transaction")
+
+	ok, err = d.ReverifyPassword(context.Background(), "sid", "wrong")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = d.ReverifyPassword(context.Background(), "", "secret")
+	assert.Equal(t, oktadance.ErrEmptySessionID, err)
+}
+
+func TestDance_EstablishSession(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/sessionCookieRedirect", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("token") != "token" {
+			writeJSON(w, http.StatusForbidden, map[string]interface{}{
+				"errorCode":    "E0000011",
+				"errorSummary": "Invalid token provided",
+			})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "sid123", Path: "/"})
+		w.Header().Set("Location", q.Get("redirectUrl"))
+		w.WriteHeader(http.StatusFound)
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	sid, err := d.EstablishSession(context.Background(), "token", "https://app.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionID("sid123"), sid)
+
+	_, err = d.EstablishSession(context.Background(), "used", "https://app.example.com/")
+	assert.True(t, errors.Is(err, oktadance.ErrSessionTokenConsumed), "unexpected error: %v", err)
+
+	_, err = d.EstablishSession(context.Background(), "", "https://app.example.com/")
+	assert.Equal(t, oktadance.ErrEmptySessionToken, err)
+}
+
+func TestSession_IsExpired(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := &oktadance.Session{ExpiresAt: now}
+	assert.False(t, s.IsExpired(now.Add(-time.Second)))
+	assert.True(t, s.IsExpired(now))
+	assert.True(t, s.IsExpired(now.Add(time.Second)))
+
+	d := oktadance.New("example.okta.com", oktadance.WithNowFunc(func() time.Time { return now.Add(-time.Second) }))
+	assert.False(t, d.IsSessionExpired(s))
+	assert.True(t, d.With(oktadance.WithNowFunc(func() time.Time { return now })).IsSessionExpired(s))
+}
+
+func TestSession_HasMFA(t *testing.T) {
+	tests := []struct {
+		amr    []string
+		hasMFA bool
+		aal2   bool
+	}{
+		{nil, false, false},
+		{[]string{"pwd"}, false, false},
+		{[]string{"pwd", "mfa", "otp"}, true, true},
+		{[]string{"pwd", "sms"}, true, true},
+		{[]string{"pwd", "kba"}, true, false},
+		{[]string{"mfa"}, true, false},
+	}
+	for _, tt := range tests {
+		s := &oktadance.Session{Amr: tt.amr}
+		assert.Equal(t, tt.hasMFA, s.HasMFA(), "HasMFA %v", tt.amr)
+		assert.Equal(t, tt.aal2, s.SatisfiesAAL2(), "SatisfiesAAL2 %v", tt.amr)
+	}
+}
+
+func TestSession_AuthMethods(t *testing.T) {
+	s := &oktadance.Session{Amr: []string{"pwd", "mfa", "otp", "hwk", "sms", "wia"}}
+	assert.Equal(t, []oktadance.AuthMethod{
+		oktadance.AuthMethodPassword,
+		oktadance.AuthMethodMFA,
+		oktadance.AuthMethodOTP,
+		oktadance.AuthMethodHardwareKey,
+		oktadance.AuthMethodSMS,
+		oktadance.AuthMethodUnknown,
+	}, s.AuthMethods())
+
+	s.AuthMethods()[0] = oktadance.AuthMethodPIN
+	assert.Equal(t, "pwd", s.Amr[0])
+
+	assert.Empty(t, (&oktadance.Session{}).AuthMethods())
+	assert.Equal(t, oktadance.AuthMethodUnknown, oktadance.ParseAuthMethod("unknown"))
+	assert.Equal(t, oktadance.AuthMethodKnowledge, oktadance.ParseAuthMethod("kba"))
+}
+
+func TestDance_WatchSession(t *testing.T) {
+	var mu sync.Mutex
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		switch {
+		case polls <= 2:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE", "expiresAt": expiresAt})
+		case polls == 3:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE", "expiresAt": expiresAt.Add(time.Hour)})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errorCode": "E0000007"})
+		}
+	})
+	d, srv := mockOkta(t, mux, oktadance.WithSessionWatchInterval(time.Millisecond))
+	defer srv.Close()
+
+	events, err := d.WatchSession(context.Background(), "sid")
+	require.NoError(t, err)
+
+	got := []oktadance.SessionEventType{}
+	for e := range events {
+		got = append(got, e.Type)
+	}
+	assert.Equal(t, []oktadance.SessionEventType{oktadance.SessionChanged, oktadance.SessionRevoked}, got)
+}
+
+func TestDance_WatchSession_NonPositiveInterval(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE"})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		for _, w := range []*oktadance.Dance{d.With(oktadance.WithSessionWatchInterval(interval)), d} {
+			ctx, cancel := context.WithCancel(oktadance.ContextWithOptions(context.Background(), oktadance.WithSessionWatchInterval(interval)))
+			events, err := w.WatchSession(ctx, "sid")
+			require.NoError(t, err)
+			cancel()
+			for range events {
+			}
+		}
+	}
+}
+
+func TestDance_WatchSession_Clock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls == 1 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": "sid", "status": "ACTIVE", "expiresAt": start.Add(90 * time.Minute)})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errorCode": "E0000007"})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	clock := &fakeClock{now: start}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = oktadance.ContextWithOptions(ctx, oktadance.WithClock(clock), oktadance.WithSessionWatchInterval(time.Hour))
+	events, err := d.WatchSession(ctx, "sid")
+	require.NoError(t, err)
+
+	got := []oktadance.SessionEventType{}
+	for e := range events {
+		got = append(got, e.Type)
+	}
+	// an hour in, by the per call clock, the session had not yet expired
+	assert.Equal(t, []oktadance.SessionEventType{oktadance.SessionRevoked}, got)
+	assert.Equal(t, []time.Duration{time.Hour}, clock.waits)
+}
+
+func TestDance_MySessions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/me/sessions", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("sid")
+		require.NoError(t, err)
+		assert.Equal(t, "sid1", c.Value)
+		assert.Empty(t, r.Header.Get("Authorization"))
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"id": "sid1", "status": "ACTIVE", "amr": []string{"pwd"}},
+			{"id": "sid2", "status": "ACTIVE", "amr": []string{"pwd", "mfa", "otp"}},
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	sessions, err := d.MySessions(context.Background(), "sid1")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "sid2", sessions[1].ID)
+	assert.True(t, sessions[1].HasMFA())
+}
@@ -0,0 +1,45 @@
+package oktadance
+
+// AuthnStatus is the status of an authn transaction, see
+// [Transaction State](https://developer.okta.com/docs/reference/api/authn/#transaction-state)
+type AuthnStatus string
+
+// The states of an authn transaction
+const (
+	StatusUnauthenticated   AuthnStatus = "UNAUTHENTICATED"
+	StatusSuccess           AuthnStatus = "SUCCESS"
+	StatusMFARequired       AuthnStatus = "MFA_REQUIRED"
+	StatusMFAChallenge      AuthnStatus = "MFA_CHALLENGE"
+	StatusMFAEnroll         AuthnStatus = "MFA_ENROLL"
+	StatusMFAEnrollActivate AuthnStatus = "MFA_ENROLL_ACTIVATE"
+	StatusPasswordWarn      AuthnStatus = "PASSWORD_WARN"
+	StatusPasswordExpired   AuthnStatus = "PASSWORD_EXPIRED"
+	StatusPasswordReset     AuthnStatus = "PASSWORD_RESET"
+	StatusRecovery          AuthnStatus = "RECOVERY"
+	StatusRecoveryChallenge AuthnStatus = "RECOVERY_CHALLENGE"
+	StatusLockedOut         AuthnStatus = "LOCKED_OUT"
+)
+
+// SessionStatus is the status of an Okta Session
+type SessionStatus string
+
+// The states of a Session
+const (
+	SessionActive      SessionStatus = "ACTIVE"
+	SessionMFARequired SessionStatus = "MFA_REQUIRED"
+	SessionInactive    SessionStatus = "INACTIVE"
+)
+
+// FactorResult is the outcome of verifying a factor, reported alongside
+// the `AuthnStatus` while a challenge, such as a push, is pending
+type FactorResult string
+
+// The outcomes of verifying a factor
+const (
+	FactorResultWaiting   FactorResult = "WAITING"
+	FactorResultSuccess   FactorResult = "SUCCESS"
+	FactorResultRejected  FactorResult = "REJECTED"
+	FactorResultTimeout   FactorResult = "TIMEOUT"
+	FactorResultCancelled FactorResult = "CANCELLED"
+	FactorResultError     FactorResult = "ERROR"
+)
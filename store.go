@@ -0,0 +1,152 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SessionStore persists sessions between runs, ie so a CLI does not need
+// the user to log in every time it is run. Sessions are keyed by the
+// Okta domain they belong to.
+type SessionStore interface {
+	// Save the session for the domain, replacing any already saved
+	Save(domain string, sess *Session, sid SessionID) error
+
+	// Load the session saved for the domain, if any
+	Load(domain string) (SessionID, bool)
+}
+
+// SessionFromStore returns the session saved in the store for the dance's
+// domain, if it is still active. If there is none, or Okta reports it has
+// ended, login is called to obtain a new session, ie via `Authenticate`
+// and `Authorize`, which is saved to the store before being returned.
+// Any other failure to check the saved session, such as Okta being
+// unreachable, is returned rather than logging in again.
+func (d *Dance) SessionFromStore(ctx context.Context, store SessionStore, login func(context.Context) (SessionID, error)) (SessionID, *Session, error) {
+	if sid, ok := store.Load(d.oktaDomain); ok {
+		sess, err := d.Session(ctx, sid)
+		switch {
+		case errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionInactive):
+			// ended, log in again
+		case err != nil:
+			return "", nil, err
+		case sess.Status == SessionActive:
+			return sid, sess, nil
+		}
+	}
+
+	sid, err := login(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sess, err := d.Session(ctx, sid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = store.Save(d.oktaDomain, sess, sid)
+	if err != nil {
+		return "", nil, err
+	}
+	return sid, sess, nil
+}
+
+// NewFileSessionStore creates a `SessionStore` which saves sessions to
+// a JSON file at path. As the file holds session IDs, which grant access
+// to the user's Okta account, it is only readable by the current user.
+func NewFileSessionStore(path string) *FileSessionStore {
+	return &FileSessionStore{path: path}
+}
+
+// FileSessionStore is a `SessionStore` backed by a file
+type FileSessionStore struct {
+	// Now gives the current time, to check whether a saved session has
+	// expired, time.Now if nil
+	Now func() time.Time
+
+	path string
+	mu   sync.Mutex
+}
+
+type storedSession struct {
+	SessionID SessionID `json:"sid"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Save the session for the domain to the file
+func (f *FileSessionStore) Save(domain string, sess *Session, sid SessionID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sessions := f.read()
+	sessions[domain] = storedSession{SessionID: sid, ExpiresAt: sess.ExpiresAt}
+
+	buf, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(f.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file and rename it into place, so the file
+	// is never partially written or readable by others
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(0600)
+	if err == nil {
+		_, err = tmp.Write(buf)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Load the session saved for the domain, if it has not expired
+func (f *FileSessionStore) Load(domain string) (SessionID, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.read()[domain]
+	if !ok || !s.SessionID.Valid() {
+		return "", false
+	}
+	now := time.Now
+	if f.Now != nil {
+		now = f.Now
+	}
+	if !s.ExpiresAt.IsZero() && now().After(s.ExpiresAt) {
+		return "", false
+	}
+	return s.SessionID, true
+}
+
+// read the saved sessions, ignoring a missing or corrupt file
+func (f *FileSessionStore) read() map[string]storedSession {
+	sessions := map[string]storedSession{}
+	buf, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return sessions
+	}
+	if json.Unmarshal(buf, &sessions) != nil {
+		return map[string]storedSession{}
+	}
+	return sessions
+}
@@ -0,0 +1,105 @@
+package oktadance_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brianm/oktadance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oktadance")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config", "sessions.json")
+	store := oktadance.NewFileSessionStore(path)
+
+	_, ok := store.Load("example.okta.com")
+	assert.False(t, ok)
+
+	err = store.Save("example.okta.com", &oktadance.Session{ExpiresAt: time.Now().Add(time.Hour)}, "sid1")
+	require.NoError(t, err)
+	err = store.Save("expired.okta.com", &oktadance.Session{ExpiresAt: time.Now().Add(-time.Hour)}, "sid2")
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	store = oktadance.NewFileSessionStore(path)
+	sid, ok := store.Load("example.okta.com")
+	assert.True(t, ok)
+	assert.Equal(t, oktadance.SessionID("sid1"), sid)
+
+	_, ok = store.Load("expired.okta.com")
+	assert.False(t, ok)
+
+	store.Now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	sid, ok = store.Load("expired.okta.com")
+	assert.True(t, ok, "not expired by the store's clock")
+	assert.Equal(t, oktadance.SessionID("sid2"), sid)
+}
+
+type memoryStore map[string]oktadance.SessionID
+
+func (m memoryStore) Save(domain string, sess *oktadance.Session, sid oktadance.SessionID) error {
+	m[domain] = sid
+	return nil
+}
+
+func (m memoryStore) Load(domain string) (oktadance.SessionID, bool) {
+	sid, ok := m[domain]
+	return sid, ok
+}
+
+func TestDance_SessionFromStore(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions/me", func(w http.ResponseWriter, r *http.Request) {
+		c, _ := r.Cookie("sid")
+		if c.Value == "stale" {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errorCode": "E0000007"})
+			return
+		}
+		if c.Value == "unavailable" {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"errorCode": "E0000010"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": c.Value, "status": "ACTIVE"})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	logins := 0
+	login := func(context.Context) (oktadance.SessionID, error) {
+		logins++
+		return "fresh", nil
+	}
+
+	store := memoryStore{}
+	store[srv.Listener.Addr().String()] = "stale"
+
+	sid, sess, err := d.SessionFromStore(context.Background(), store, login)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionID("fresh"), sid)
+	assert.Equal(t, "fresh", sess.ID)
+	assert.Equal(t, 1, logins)
+
+	sid, _, err = d.SessionFromStore(context.Background(), store, login)
+	require.NoError(t, err)
+	assert.Equal(t, oktadance.SessionID("fresh"), sid)
+	assert.Equal(t, 1, logins, "cached session should be reused")
+
+	// an outage is reported, rather than every caller logging in again
+	store[srv.Listener.Addr().String()] = "unavailable"
+	_, _, err = d.SessionFromStore(context.Background(), store, login)
+	assert.Error(t, err)
+	assert.Equal(t, 1, logins, "login should not be attempted when the session could not be checked")
+}
@@ -0,0 +1,58 @@
+package oktadance
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// WithDialTimeout bounds how long the default http client waits to
+// connect to Okta, so that an unreachable network fails fast rather than
+// waiting on the context. It may not be combined with `WithHTTPClient`;
+// configure that client's transport instead.
+func WithDialTimeout(timeout time.Duration) Option {
+	return clientOption(func(d *Dance) {
+		d.dialTimeout = timeout
+	})
+}
+
+// WithTLSHandshakeTimeout bounds how long the default http client waits
+// for the TLS handshake with Okta. Like `WithDialTimeout`, it may not be
+// combined with `WithHTTPClient`.
+func WithTLSHandshakeTimeout(timeout time.Duration) Option {
+	return clientOption(func(d *Dance) {
+		d.tlsHandshakeTimeout = timeout
+	})
+}
+
+// WithResponseHeaderTimeout bounds how long the default http client waits
+// for Okta to respond once a request has been sent. Each poll of a
+// pending push is a separate request, so this does not limit how long
+// the user has to respond. Like `WithDialTimeout`, it may not be combined
+// with `WithHTTPClient`.
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+	return clientOption(func(d *Dance) {
+		d.responseHeaderTimeout = timeout
+	})
+}
+
+// hasTransportTimeouts reports whether any transport timeout is configured
+func (d *Dance) hasTransportTimeouts() bool {
+	return d.dialTimeout > 0 || d.tlsHandshakeTimeout > 0 || d.responseHeaderTimeout > 0
+}
+
+// applyTransportTimeouts sets the configured timeouts on t
+func (d *Dance) applyTransportTimeouts(t *http.Transport) {
+	if d.dialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   d.dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if d.tlsHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = d.tlsHandshakeTimeout
+	}
+	if d.responseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = d.responseHeaderTimeout
+	}
+}
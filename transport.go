@@ -0,0 +1,34 @@
+package oktadance
+
+import (
+	"net/http"
+)
+
+// WithTransportWrapper layers middleware, such as metrics or tracing, over
+// the transport used to reach Okta, while keeping the client's redirect
+// policy. The wrapper is given the transport the dance would otherwise
+// use, including any configured via `WithInsecureSkipVerify` or the
+// timeout options, and returns the transport to use instead.
+//
+// Combined with `WithHTTPClient`, the given client is left untouched and
+// a copy of it with the wrapped transport is used.
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return clientOption(func(d *Dance) {
+		d.transportWrapper = wrap
+	})
+}
+
+// wrapTransport applies the transport wrapper, if any, to a copy of the
+// client
+func (d *Dance) wrapTransport() {
+	if d.transportWrapper == nil {
+		return
+	}
+	hc := *d.httpClient
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hc.Transport = d.transportWrapper(base)
+	d.httpClient = &hc
+}
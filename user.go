@@ -0,0 +1,120 @@
+package oktadance
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// User is an Okta User, see
+// [User Model](https://developer.okta.com/docs/reference/api/users/#user-object)
+type User struct {
+	ID              string      `json:"id"`
+	Status          string      `json:"status"`
+	Created         time.Time   `json:"created"`
+	Activated       time.Time   `json:"activated"`
+	LastLogin       time.Time   `json:"lastLogin"`
+	LastUpdated     time.Time   `json:"lastUpdated"`
+	PasswordChanged time.Time   `json:"passwordChanged"`
+	Profile         UserProfile `json:"profile"`
+}
+
+// UserProfile holds the standard profile attributes of an Okta User
+type UserProfile struct {
+	Login       string `json:"login"`
+	Email       string `json:"email"`
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	DisplayName string `json:"displayName"`
+	MobilePhone string `json:"mobilePhone"`
+}
+
+// Me retrieves the profile of the user who owns the session. Like
+// `Session`, it only needs the sessionId, not an API token.
+func (d *Dance) Me(ctx context.Context, sessionID SessionID) (*User, error) {
+	if !sessionID.Valid() {
+		return nil, ErrEmptySessionID
+	}
+
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	body, err := d.sessionAPI(ctx, "Me", "GET", "/api/v1/users/me", sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{}
+	err = json.Unmarshal(body, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UserInfo holds the claims returned by the OIDC userinfo endpoint
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+
+	// Claims holds every claim returned, including those above
+	Claims map[string]interface{} `json:"-"`
+}
+
+// UserInfo retrieves the standard claims about the user to whom the
+// access token was issued, from the authorization server's userinfo
+// endpoint. Which claims are returned depends on the scopes granted.
+func (d *Dance) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	d, ctx, cancel := d.call(ctx)
+	defer cancel()
+
+	u, err := d.endpoint(ctx, "userinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.do("UserInfo", req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJSON(res, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, oktaError(res, body)
+	}
+
+	info := &UserInfo{}
+	err = json.Unmarshal(body, info)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &info.Claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,76 @@
+package oktadance_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDance_Me(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users/me", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("sid")
+		if err != nil || c.Value != "sid123" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"errorCode": "E0000005"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":     "00u1",
+			"status": "ACTIVE",
+			"profile": map[string]interface{}{
+				"login":     "user@example.com",
+				"email":     "user@example.com",
+				"firstName": "Brian",
+				"lastName":  "McCallister",
+			},
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	user, err := d.Me(context.Background(), "sid123")
+	require.NoError(err)
+	assert.Equal("00u1", user.ID)
+	assert.Equal("ACTIVE", user.Status)
+	assert.Equal("Brian", user.Profile.FirstName)
+	assert.Equal("user@example.com", user.Profile.Login)
+
+	_, err = d.Me(context.Background(), "bogus")
+	assert.Error(err)
+}
+
+func TestDance_UserInfo(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"sub":            "00u1",
+			"email":          "brian@example.com",
+			"email_verified": true,
+			"name":           "Brian",
+			"zoneinfo":       "America/Los_Angeles",
+		})
+	})
+	d, srv := mockOkta(t, mux)
+	defer srv.Close()
+
+	info, err := d.UserInfo(context.Background(), "access")
+	require.NoError(t, err)
+	assert.Equal(t, "00u1", info.Subject)
+	assert.Equal(t, "brian@example.com", info.Email)
+	assert.True(t, info.EmailVerified)
+	assert.Equal(t, "Brian", info.Name)
+	assert.Equal(t, "America/Los_Angeles", info.Claims["zoneinfo"])
+
+	_, err = d.UserInfo(context.Background(), "wrong")
+	assert.Error(t, err)
+}